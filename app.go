@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"mini-ebook/internal/job"
+)
+
+// App 整个应用需要启动的东西
+type App struct {
+	server *gin.Engine
+	// purgeUserJob 清理注销用户的后台任务
+	purgeUserJob *job.PurgeDeletedUserJob
+}
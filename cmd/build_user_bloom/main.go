@@ -0,0 +1,38 @@
+// build_user_bloom 把已有的用户 id 全部加到布隆过滤器里，开启布隆过滤器之前要先跑一遍
+// 使用方式：go run ./cmd/build_user_bloom ，k8s 环境记得带上 -tags=k8s
+package main
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"log"
+	"mini-ebook/internal/repository/dao"
+	"mini-ebook/ioc"
+)
+
+func main() {
+	ctx := context.Background()
+	// 分库了就遍历每个分库，没分库所有用户都在主库
+	dbs := ioc.InitUserShardDBs()
+	if len(dbs) == 0 {
+		dbs = []*gorm.DB{ioc.InitDB()}
+	}
+	bloom := ioc.InitUserBloomFilter(ioc.InitRedis())
+
+	var added int
+	for _, db := range dbs {
+		err := dao.WalkUserIds(ctx, db, func(ids []int64) error {
+			for _, id := range ids {
+				if err := bloom.Add(ctx, id); err != nil {
+					return err
+				}
+			}
+			added += len(ids)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("构建失败，已添加 %d 个：%v", added, err)
+		}
+	}
+	log.Printf("构建完成，共添加 %d 个", added)
+}
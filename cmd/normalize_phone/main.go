@@ -0,0 +1,30 @@
+// normalize_phone 一次性的数据迁移工具，把历史数据里的手机号码统一转成 E.164 格式
+// 使用方式：go run ./cmd/normalize_phone ，k8s 环境记得带上 -tags=k8s
+package main
+
+import (
+	"context"
+	"log"
+	"mini-ebook/config"
+	"mini-ebook/internal/repository/dao"
+	"mini-ebook/ioc"
+	"mini-ebook/pkg/phone"
+)
+
+// 历史数据里不带区号的号码，都是中国大陆的号码
+const defaultRegion = "CN"
+
+func main() {
+	// 分库之后手机号还要同步到索引表，这个工具只处理没分库的情况，要在分库之前跑
+	if len(config.Config.DB.Shards) > 0 {
+		log.Fatal("用户已经分库，不能再用这个工具")
+	}
+	db := ioc.InitPrimaryDB()
+	updated, err := dao.NormalizePhones(context.Background(), db, func(raw string) (string, error) {
+		return phone.Parse(raw, defaultRegion)
+	})
+	if err != nil {
+		log.Fatalf("迁移失败，已更新 %d 条：%v", updated, err)
+	}
+	log.Printf("迁移完成，共更新 %d 条", updated)
+}
@@ -0,0 +1,91 @@
+// reshard_users 调整用户分库，第一次分库也用它
+// 使用方式（k8s 环境记得带上 -tags=k8s）：
+//  1. 停止写入；
+//  2. go run ./cmd/reshard_users -to dsn1,dsn2,dsn3 copy ，把当前配置里的分库（没分库就是主库）的用户搬到新的分库，并重建索引表；
+//  3. 把配置里的 DB.Shards 改成新的分库并发布；
+//  4. go run ./cmd/reshard_users cleanup ，按照当前配置删掉不属于各个库的用户。
+package main
+
+import (
+	"context"
+	"flag"
+	"gorm.io/gorm"
+	"log"
+	"mini-ebook/config"
+	"mini-ebook/internal/repository/dao"
+	"mini-ebook/ioc"
+	"strings"
+)
+
+const usage = "用法：reshard_users -to dsn1,dsn2 copy 或者 reshard_users cleanup"
+
+func main() {
+	to := flag.String("to", "", "新的分库，逗号分隔，顺序就是以后配置里的顺序")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal(usage)
+	}
+	ctx := context.Background()
+
+	switch flag.Arg(0) {
+	case "copy":
+		if *to == "" {
+			log.Fatal(usage)
+		}
+		copyUsers(ctx, strings.Split(*to, ","))
+	case "cleanup":
+		// 清理要看到最新的数据，不能读从库
+		shards := ioc.InitUserShardPrimaryDBs()
+		if len(shards) == 0 {
+			log.Fatal("配置里没有分库")
+		}
+		deleted, err := dao.CleanupShards(ctx, shards)
+		if err != nil {
+			log.Fatalf("清理失败，已删除 %d 个：%v", deleted, err)
+		}
+		log.Printf("清理完成，共删除 %d 个", deleted)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func copyUsers(ctx context.Context, toDSNs []string) {
+	index := ioc.InitPrimaryDB()
+	fromDSNs := config.Config.DB.Shards
+	if len(fromDSNs) == 0 {
+		fromDSNs = []string{config.Config.DB.DSN}
+	}
+
+	// 同一个 DSN 只打开一次，新旧分库里都有的库就不用搬了
+	dbs := map[string]*gorm.DB{config.Config.DB.DSN: index}
+	open := func(dsns []string) []*gorm.DB {
+		res := make([]*gorm.DB, 0, len(dsns))
+		for _, dsn := range dsns {
+			db, ok := dbs[dsn]
+			if !ok {
+				db = ioc.OpenDB(dsn)
+				dbs[dsn] = db
+			}
+			res = append(res, db)
+		}
+		return res
+	}
+	from, to := open(fromDSNs), open(toDSNs)
+
+	// 新的分库可能还没有建表
+	for _, db := range to {
+		m, err := dao.NewMigrator(db)
+		if err != nil {
+			log.Fatalf("读取迁移文件失败：%v", err)
+		}
+		if _, err = m.Up(ctx); err != nil {
+			log.Fatalf("新的分库迁移失败：%v", err)
+		}
+	}
+
+	moved, err := dao.ReshardUsers(ctx, index, from, to)
+	if err != nil {
+		log.Fatalf("搬迁失败，已搬 %d 个：%v", moved, err)
+	}
+	log.Printf("搬迁完成，共搬了 %d 个，现在可以把配置改成新的分库了", moved)
+}
@@ -1,8 +1,11 @@
-//go:build !k8s
+//go:build !k8s && !local
 
 package config
 
 var Config = config{
 	DB:    DBConfig{DSN: "root:root@tcp(localhost:13316)/mini_ebook"},
 	Redis: RedisConfig{Addr: "localhost:6379"},
+	// 线上环境的密钥不能和这里一样
+	MagicLink: MagicLinkConfig{Key: "Qy8pZc3RkT1nW6hX0aLmVb5sJd2fGe7u"},
+	SMS:       SMSConfig{CodeTplId: "1877556", PhoneChangedTplId: "1877557"},
 }
@@ -2,7 +2,16 @@
 
 package config
 
+import "os"
+
 var Config = config{
 	DB:    DBConfig{DSN: "root:root@tcp(mini-book-record-mysql:3308)/mini_ebook"},
 	Redis: RedisConfig{Addr: "mini-book-record-redis:6380"},
+	// 多个副本，worker id 不能写死
+	Snowflake: SnowflakeConfig{WorkerIdFromRedis: true},
+	// 密钥不进代码仓库，从 Secret 注入的环境变量里读
+	MagicLink: MagicLinkConfig{Key: os.Getenv("MAGIC_LINK_KEY")},
+	SMS:       SMSConfig{CodeTplId: "1877556", PhoneChangedTplId: "1877557"},
+	// 请求经过集群里的 Ingress 转发，只相信集群内网地址带过来的 X-Forwarded-For
+	Web: WebConfig{TrustedProxies: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}},
 }
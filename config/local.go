@@ -0,0 +1,12 @@
+//go:build local
+
+package config
+
+// Config 本地开发用 SQLite，不需要启动 docker-compose 里的 MySQL
+var Config = config{
+	DB:    DBConfig{Driver: "sqlite", DSN: "mini-ebook.db"},
+	Redis: RedisConfig{Addr: "localhost:6379"},
+	// 线上环境的密钥不能和这里一样
+	MagicLink: MagicLinkConfig{Key: "Qy8pZc3RkT1nW6hX0aLmVb5sJd2fGe7u"},
+	SMS:       SMSConfig{CodeTplId: "1877556", PhoneChangedTplId: "1877557"},
+}
@@ -1,14 +1,87 @@
 package config
 
+import "time"
+
 type config struct {
-	DB    DBConfig
-	Redis RedisConfig
+	DB        DBConfig
+	Redis     RedisConfig
+	UserCache UserCacheConfig
+	Snowflake SnowflakeConfig
+	MagicLink MagicLinkConfig
+	SMS       SMSConfig
+	Web       WebConfig
+}
+
+type WebConfig struct {
+	// TrustedProxies 反向代理的 IP 或者网段，只有请求直接来自这些地址的时候才相信 X-Forwarded-For
+	// 为空的时候不相信任何代理，直接用连接的对端地址作为客户端 IP
+	TrustedProxies []string
 }
 
 type DBConfig struct {
+	// Driver mysql 或者 sqlite，默认 mysql；sqlite 只用于本地开发和测试，DSN 填文件路径或者 file::memory:
+	Driver string
+	// DSN 主库
 	DSN string
+	// Replicas 从库，为空的时候读写都走主库
+	Replicas []string
+	// Shards 用户分库，按用户 id 路由，为空的时候不分库；邮箱、手机号的索引表在主库上
+	// 顺序不能随便调整，增减分库要先用 cmd/reshard_users 搬数据
+	Shards []string
+	// ShardReplicas 分库的从库，ShardReplicas[i] 对应 Shards[i]，没有配置的分库读写都走分库的主库
+	ShardReplicas [][]string
 }
 
 type RedisConfig struct {
+	// Mode standalone（默认）、sentinel 或者 cluster
+	Mode string
+	// Addr standalone 模式下的地址
 	Addr string
+	// Addrs sentinel 模式下是哨兵的地址，cluster 模式下是集群节点的地址
+	Addrs []string
+	// MasterName sentinel 模式下主节点的名字
+	MasterName string
+	Username   string
+	Password   string
+	// DB cluster 模式不支持
+	DB int
+	// PoolSize 每个节点的连接池大小，0 表示用 go-redis 的默认值
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	TLS          bool
+	// KeyPrefix 所有 key 的前缀，多个应用共用一个 Redis 的时候用来隔离，不能有花括号
+	KeyPrefix string
+}
+
+type UserCacheConfig struct {
+	// BloomFilter 开启之前要先跑一遍 cmd/build_user_bloom
+	BloomFilter bool
+	// RebuildLock 缓存重建的时候加分布式锁，多个实例只有一个去查数据库
+	RebuildLock bool
+	// DoubleDeleteDelay 延迟双删的间隔，0 表示不开启；要比一次缓存重建的耗时长，配置了从库的话还要加上主从延迟
+	DoubleDeleteDelay time.Duration
+}
+
+// SnowflakeConfig 用户 id 生成器的配置
+type SnowflakeConfig struct {
+	// WorkerId 单实例部署的时候直接配置，范围是 0 到 1023
+	WorkerId int64
+	// WorkerIdFromRedis 为 true 的时候忽略 WorkerId，每个实例启动的时候从 Redis 租一个
+	WorkerIdFromRedis bool
+}
+
+// MagicLinkConfig 邮件登录链接的配置
+type MagicLinkConfig struct {
+	// Key 签名 token 的密钥，至少 32 字节，泄露了别人就可以伪造登录链接
+	Key string
+}
+
+// SMSConfig 短信的配置，模板 id 在短信服务商的控制台上申请
+type SMSConfig struct {
+	// CodeTplId 验证码模板
+	CodeTplId string
+	// PhoneChangedTplId 换绑手机号之后通知旧手机号的模板
+	PhoneChangedTplId string
 }
@@ -0,0 +1,28 @@
+package domain
+
+// CodeVerifyStatus 验证码校验的结果类型
+type CodeVerifyStatus uint8
+
+const (
+	// CodeVerifyOk 验证通过
+	CodeVerifyOk CodeVerifyStatus = iota
+	// CodeVerifyMismatch 验证码不对，但是还可以继续尝试
+	CodeVerifyMismatch
+	// CodeVerifyExpired 验证码已经过期，或者压根就没有发送过
+	CodeVerifyExpired
+	// CodeVerifyExhausted 验证次数已经用完
+	CodeVerifyExhausted
+	// CodeVerifyUsed 验证码已经验证通过过一次了，不能重复使用
+	CodeVerifyUsed
+)
+
+// CodeVerifyResult 验证码校验结果
+type CodeVerifyResult struct {
+	Status CodeVerifyStatus
+	// Remaining 剩余的可验证次数
+	Remaining int
+}
+
+func (r CodeVerifyResult) Ok() bool {
+	return r.Status == CodeVerifyOk
+}
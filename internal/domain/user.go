@@ -10,4 +10,40 @@ type User struct {
 	Birthday time.Time
 	AboutMe  string
 	Phone    string
+	// Avatar 头像的 URL，同一个目录下还有一个 64 像素的缩略图
+	Avatar string
+	// TokenVersion 每次修改密码都会加一，签发的 token 里带着这个版本号，版本号对不上的 token 就失效了
+	TokenVersion int64
+	Ctime        time.Time
+	Utime        time.Time
+	Privacy      PrivacySettings
+	Role         UserRole
+	// Version 资料的版本号，修改资料的时候要带上读到的版本号，中间被别的设备改过了就会冲突
+	Version int64
+}
+
+// PrivacySettings 用户的隐私设置
+type PrivacySettings struct {
+	// HideBirthday 公开资料里不展示生日
+	HideBirthday bool
+	// HideAboutMe 公开资料里不展示个人简介
+	HideAboutMe bool
+	// HideFromSearch 不出现在用户搜索结果里
+	HideFromSearch bool
+}
+
+// BirthdayFromMillis 数据库和缓存里的 0 表示没有填生日，转成零值，不然会被当成 1970-01-01
+func BirthdayFromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// BirthdayToMillis 和 BirthdayFromMillis 相反，没有填生日存 0
+func BirthdayToMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
 }
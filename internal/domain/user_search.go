@@ -0,0 +1,42 @@
+package domain
+
+import "time"
+
+// UserRole 用户的角色
+type UserRole uint8
+
+const (
+	UserRoleNormal UserRole = iota
+	UserRoleAdmin
+)
+
+// UserSortField 用户列表的排序字段
+type UserSortField uint8
+
+const (
+	UserSortById UserSortField = iota
+	UserSortByCtime
+)
+
+// UserSearch 管理后台查询用户的条件
+type UserSearch struct {
+	// CtimeStart 和 CtimeEnd 是注册时间的范围，左闭右开，零值表示不限制
+	CtimeStart time.Time
+	CtimeEnd   time.Time
+	// HasPhone 和 HasEmail 为 nil 表示不限制
+	HasPhone       *bool
+	HasEmail       *bool
+	NicknamePrefix string
+
+	SortBy UserSortField
+	Desc   bool
+	// After 上一页最后一个用户的位置，nil 表示从第一页开始
+	After *UserCursor
+	Limit int
+}
+
+// UserCursor 分页的游标，按照排序字段加上 id 唯一确定一个位置
+type UserCursor struct {
+	Ctime time.Time
+	Id    int64
+}
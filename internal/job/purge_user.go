@@ -0,0 +1,51 @@
+package job
+
+import (
+	"context"
+	"log"
+	"mini-ebook/internal/service"
+	"time"
+)
+
+// PurgeDeletedUserJob 定时彻底删除已经过了保留期的注销用户
+// 多个实例同时跑也没有关系，删除本身是幂等的
+type PurgeDeletedUserJob struct {
+	svc service.UserService
+	// interval 多久跑一次
+	interval time.Duration
+	// grace 注销之后数据保留多久
+	grace time.Duration
+}
+
+func NewPurgeDeletedUserJob(svc service.UserService) *PurgeDeletedUserJob {
+	return &PurgeDeletedUserJob{
+		svc:      svc,
+		interval: time.Hour,
+		grace:    time.Hour * 24 * 30,
+	}
+}
+
+// Start 阻塞运行，直到 ctx 被取消
+func (j *PurgeDeletedUserJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.Run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run 执行一次清理
+func (j *PurgeDeletedUserJob) Run(ctx context.Context) {
+	n, err := j.svc.PurgeDeleted(ctx, time.Now().Add(-j.grace))
+	if err != nil {
+		log.Println("清理注销用户失败", err)
+	}
+	if n > 0 {
+		log.Printf("清理了 %d 个注销用户", n)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"mini-ebook/internal/domain"
 )
 
 var (
@@ -14,13 +15,12 @@ var (
 	//go:embed lua/verify_code.lua
 	luaVerifyCode string
 
-	ErrCodeSendToMany   = errors.New("发送太频繁")
-	ErrCodeVerifyToMany = errors.New("发送太频繁")
+	ErrCodeSendToMany = errors.New("发送太频繁")
 )
 
 type CodeCache interface {
 	Set(ctx context.Context, biz, phone, code string) error
-	Verify(ctx context.Context, biz, phone, code string) (bool, error)
+	Verify(ctx context.Context, biz, phone, code string) (domain.CodeVerifyResult, error)
 }
 
 type RedisCodeCache struct {
@@ -34,7 +34,7 @@ func NewCodeCache(cmd redis.Cmdable) CodeCache {
 }
 
 func (c *RedisCodeCache) Set(ctx context.Context, biz, phone, code string) error {
-	res, err := c.cmd.Eval(ctx, luaSetCode, []string{c.Key(biz, phone)}, code).Int()
+	res, err := c.cmd.Eval(ctx, luaSetCode, c.keys(biz, phone), code).Int()
 	if err != nil {
 		// 调用 redis 出了问题
 		return err
@@ -50,23 +50,41 @@ func (c *RedisCodeCache) Set(ctx context.Context, biz, phone, code string) error
 	}
 }
 
-func (c *RedisCodeCache) Verify(ctx context.Context, biz, phone, code string) (bool, error) {
-	res, err := c.cmd.Eval(ctx, luaVerifyCode, []string{c.Key(biz, phone)}, code).Int()
+func (c *RedisCodeCache) Verify(ctx context.Context, biz, phone, code string) (domain.CodeVerifyResult, error) {
+	res, err := c.cmd.Eval(ctx, luaVerifyCode, c.keys(biz, phone), code).Int64Slice()
 	if err != nil {
 		// 调用 redis 出了问题
-		return false, err
+		return domain.CodeVerifyResult{}, err
+	}
+	if len(res) != 2 {
+		return domain.CodeVerifyResult{}, fmt.Errorf("验证码校验脚本返回值异常 %v", res)
 	}
 
-	switch res {
-	case -2:
-		return false, nil
+	remaining := int(res[1])
+	switch res[0] {
+	case 0:
+		return domain.CodeVerifyResult{Status: domain.CodeVerifyOk}, nil
 	case -1:
-		return false, ErrCodeVerifyToMany
+		return domain.CodeVerifyResult{Status: domain.CodeVerifyExhausted}, nil
+	case -2:
+		return domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: remaining}, nil
+	case -3:
+		return domain.CodeVerifyResult{Status: domain.CodeVerifyExpired}, nil
+	case -4:
+		return domain.CodeVerifyResult{Status: domain.CodeVerifyUsed}, nil
 	default:
-		return true, nil
+		return domain.CodeVerifyResult{}, fmt.Errorf("未知的验证码校验结果 %d", res[0])
 	}
 }
 
+// Key 验证码的键，phone 是 E.164 格式的号码
+// 花括号是 Redis Cluster 的 hash tag，保证验证码和验证次数两个 key 落在同一个 slot 上，Lua 脚本才能同时操作
 func (c *RedisCodeCache) Key(biz, phone string) string {
-	return fmt.Sprintf("phone_code:%s:%s", biz, phone)
+	return fmt.Sprintf("phone_code:{%s:%s}", biz, phone)
+}
+
+// keys Lua 脚本用到的所有 key：验证码、验证次数
+func (c *RedisCodeCache) keys(biz, phone string) []string {
+	key := c.Key(biz, phone)
+	return []string{key, key + ":cnt"}
 }
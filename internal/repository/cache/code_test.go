@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/internal/domain"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeEvalRedis Eval 直接返回 res，用来测试脚本返回值到校验结果的转换
+type fakeEvalRedis struct {
+	redis.Cmdable
+	res []any
+}
+
+func (f *fakeEvalRedis) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+	cmd.SetVal(f.res)
+	return cmd
+}
+
+func TestRedisCodeCache_VerifyResult(t *testing.T) {
+	testCases := []struct {
+		name    string
+		res     []any
+		want    domain.CodeVerifyResult
+		wantErr bool
+	}{
+		{name: "验证通过", res: []any{int64(0), int64(0)}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyOk}},
+		{name: "次数用完", res: []any{int64(-1), int64(0)}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyExhausted}},
+		{name: "验证码不对", res: []any{int64(-2), int64(2)}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: 2}},
+		{name: "过期", res: []any{int64(-3), int64(0)}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyExpired}},
+		{name: "已经用过", res: []any{int64(-4), int64(0)}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyUsed}},
+		{name: "未知状态", res: []any{int64(-5), int64(0)}, wantErr: true},
+		{name: "返回值个数不对", res: []any{int64(0)}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewCodeCache(&fakeEvalRedis{res: tc.res})
+			got, err := c.Verify(context.Background(), "login", "+8613800000000", "123456")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("期望返回错误 %v，实际 %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Fatalf("期望 %+v，实际 %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestRedisCodeCache_Lua 在真实的 Redis 上跑 Lua 脚本，没有配置 REDIS_ADDR 的时候跳过
+func TestRedisCodeCache_Lua(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("没有配置 REDIS_ADDR")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { _ = client.Close() })
+	ctx := context.Background()
+
+	testCases := []struct {
+		name string
+		// inputs 依次输入的验证码，只看最后一次的结果
+		inputs []string
+		send   bool
+		want   domain.CodeVerifyResult
+	}{
+		{name: "没有发送过", inputs: []string{"123456"}, want: domain.CodeVerifyResult{Status: domain.CodeVerifyExpired}},
+		{name: "验证码不对", send: true, inputs: []string{"000000"},
+			want: domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: 2}},
+		{name: "最后一次机会也输错了", send: true, inputs: []string{"000000", "000000", "000000"},
+			want: domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: 0}},
+		{name: "次数用完之后输对了也不行", send: true, inputs: []string{"000000", "000000", "000000", "123456"},
+			want: domain.CodeVerifyResult{Status: domain.CodeVerifyExhausted}},
+		{name: "输错一次之后输对了", send: true, inputs: []string{"000000", "123456"},
+			want: domain.CodeVerifyResult{Status: domain.CodeVerifyOk}},
+		{name: "不能重复使用", send: true, inputs: []string{"123456", "123456"},
+			want: domain.CodeVerifyResult{Status: domain.CodeVerifyUsed}},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewCodeCache(client).(*RedisCodeCache)
+			biz := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), i)
+			phone := "+8613800000000"
+			keys := c.keys(biz, phone)
+			t.Cleanup(func() { client.Del(ctx, keys...) })
+			if tc.send {
+				if err := c.Set(ctx, biz, phone, "123456"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got domain.CodeVerifyResult
+			for _, input := range tc.inputs {
+				var err error
+				if got, err = c.Verify(ctx, biz, phone, input); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != tc.want {
+				t.Fatalf("期望 %+v，实际 %+v", tc.want, got)
+			}
+			if tc.send {
+				// 验证次数的 key 要和验证码一起过期，不能被验证成功的时候去掉过期时间
+				if ttl := client.TTL(ctx, keys[1]).Val(); ttl <= 0 {
+					t.Fatalf("验证次数的 key 应该有过期时间，实际 %v", ttl)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/internal/domain"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotExist 缓存里没有这个 key，和 Redis 的保持一致，上层不用区分是哪一级缓存
+var ErrKeyNotExist = redis.Nil
+
+// LocalUserCache 进程内的 LRU 缓存
+// 多个实例之间的数据没法同步，所以过期时间要设置得比较短
+type LocalUserCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[int64]*list.Element
+}
+
+type localUserEntry struct {
+	uid  int64
+	user domain.User
+	// notFound 缓存的是用户不存在
+	notFound bool
+	expireAt time.Time
+}
+
+// NewLocalUserCache capacity 是最多缓存多少个用户，超过之后淘汰最久没有访问的
+func NewLocalUserCache(capacity int, ttl time.Duration) *LocalUserCache {
+	return &LocalUserCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[int64]*list.Element, capacity),
+	}
+}
+
+func (c *LocalUserCache) Get(ctx context.Context, uid int64) (domain.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[uid]
+	if !ok {
+		return domain.User{}, ErrKeyNotExist
+	}
+	entry := elem.Value.(*localUserEntry)
+	if time.Now().After(entry.expireAt) {
+		c.remove(uid, elem)
+		return domain.User{}, ErrKeyNotExist
+	}
+	c.ll.MoveToFront(elem)
+	if entry.notFound {
+		return domain.User{}, ErrUserNotExist
+	}
+	return entry.user, nil
+}
+
+func (c *LocalUserCache) Set(ctx context.Context, du domain.User) error {
+	c.set(&localUserEntry{uid: du.Id, user: du, expireAt: time.Now().Add(c.ttl)})
+	return nil
+}
+
+func (c *LocalUserCache) SetNotFound(ctx context.Context, uid int64) error {
+	c.set(&localUserEntry{uid: uid, notFound: true, expireAt: time.Now().Add(c.ttl)})
+	return nil
+}
+
+func (c *LocalUserCache) Del(ctx context.Context, uid int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[uid]; ok {
+		c.remove(uid, elem)
+	}
+	return nil
+}
+
+func (c *LocalUserCache) set(entry *localUserEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[entry.uid]; ok {
+		elem.Value = entry
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[entry.uid] = c.ll.PushFront(entry)
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.remove(oldest.Value.(*localUserEntry).uid, oldest)
+	}
+}
+
+func (c *LocalUserCache) remove(uid int64, elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, uid)
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"mini-ebook/internal/domain"
+	"testing"
+	"time"
+)
+
+func TestLocalUserCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalUserCache(2, 50*time.Millisecond)
+
+	_ = c.Set(ctx, domain.User{Id: 1})
+	_ = c.Set(ctx, domain.User{Id: 2})
+	// 访问一下 1，这样淘汰的就是 2
+	if _, err := c.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Set(ctx, domain.User{Id: 3})
+	if _, err := c.Get(ctx, 2); err != ErrKeyNotExist {
+		t.Fatalf("期望 2 被淘汰，实际 %v", err)
+	}
+	if _, err := c.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = c.Del(ctx, 1)
+	if _, err := c.Get(ctx, 1); err != ErrKeyNotExist {
+		t.Fatalf("期望 1 被删除，实际 %v", err)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if _, err := c.Get(ctx, 3); err != ErrKeyNotExist {
+		t.Fatalf("期望 3 已经过期，实际 %v", err)
+	}
+}
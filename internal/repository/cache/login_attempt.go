@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	//go:embed lua/login_attempt_check.lua
+	luaLoginAttemptCheck string
+	//go:embed lua/login_attempt_fail.lua
+	luaLoginAttemptFail string
+
+	ErrLoginLocked      = errors.New("登录失败次数过多，暂时锁定")
+	ErrLoginTooFrequent = errors.New("登录失败后重试太频繁")
+)
+
+// LoginAttemptLimit 登录失败的限制策略
+type LoginAttemptLimit struct {
+	// DelayAfter 失败多少次之后，每次重试前都要等待一段时间，等待时间从 1 秒开始翻倍
+	DelayAfter int
+	// MaxDelay 最长等待时间
+	MaxDelay time.Duration
+	// LockAfter 失败多少次之后锁定
+	LockAfter int
+	// LockDuration 锁定多久
+	LockDuration time.Duration
+	// Window 超过这个时间没有再失败，就重新计数
+	Window time.Duration
+}
+
+type LoginAttemptCache interface {
+	// Check 检查是否允许登录，不允许的时候返回 ErrLoginLocked 或者 ErrLoginTooFrequent，以及还需要等待的时间
+	Check(ctx context.Context, biz, id string, limit LoginAttemptLimit) (time.Duration, error)
+	// Fail 记录一次失败
+	Fail(ctx context.Context, biz, id string, limit LoginAttemptLimit) error
+	// Reset 清空失败计数
+	Reset(ctx context.Context, biz, id string) error
+}
+
+type RedisLoginAttemptCache struct {
+	cmd redis.Cmdable
+}
+
+func NewLoginAttemptCache(cmd redis.Cmdable) LoginAttemptCache {
+	return &RedisLoginAttemptCache{
+		cmd: cmd,
+	}
+}
+
+func (c *RedisLoginAttemptCache) Check(ctx context.Context, biz, id string, limit LoginAttemptLimit) (time.Duration, error) {
+	res, err := c.cmd.Eval(ctx, luaLoginAttemptCheck, []string{c.key(biz, id)},
+		time.Now().UnixMilli(), limit.DelayAfter, limit.MaxDelay.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 2 {
+		return 0, fmt.Errorf("登录失败检查脚本返回值异常 %v", res)
+	}
+
+	wait := time.Duration(res[1]) * time.Millisecond
+	switch res[0] {
+	case 0:
+		return 0, nil
+	case -1:
+		return wait, ErrLoginLocked
+	case -2:
+		return wait, ErrLoginTooFrequent
+	default:
+		return 0, fmt.Errorf("未知的登录失败检查结果 %d", res[0])
+	}
+}
+
+func (c *RedisLoginAttemptCache) Fail(ctx context.Context, biz, id string, limit LoginAttemptLimit) error {
+	return c.cmd.Eval(ctx, luaLoginAttemptFail, []string{c.key(biz, id)}, time.Now().UnixMilli(),
+		limit.LockAfter, limit.LockDuration.Milliseconds(), limit.Window.Milliseconds()).Err()
+}
+
+func (c *RedisLoginAttemptCache) Reset(ctx context.Context, biz, id string) error {
+	return c.cmd.Del(ctx, c.key(biz, id)).Err()
+}
+
+func (c *RedisLoginAttemptCache) key(biz, id string) string {
+	return fmt.Sprintf("login_attempt:%s:%s", biz, id)
+}
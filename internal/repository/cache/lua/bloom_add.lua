@@ -0,0 +1,7 @@
+-- 布隆过滤器的 bitmap
+local key = KEYS[1]
+-- ARGV 是需要设置成 1 的所有位置
+for i = 1, #ARGV do
+    redis.call("setbit", key, ARGV[i], 1)
+end
+return 0
@@ -0,0 +1,9 @@
+-- 布隆过滤器的 bitmap
+local key = KEYS[1]
+-- ARGV 里面所有位置都是 1 才可能存在
+for i = 1, #ARGV do
+    if redis.call("getbit", key, ARGV[i]) == 0 then
+        return 0
+    end
+end
+return 1
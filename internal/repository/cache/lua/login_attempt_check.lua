@@ -0,0 +1,30 @@
+-- 登录失败计数的 key，也就是 login_attempt:维度:账号或者IP
+local key = KEYS[1]
+-- 当前时间，毫秒
+local now = tonumber(ARGV[1])
+-- 失败多少次之后开始要求等待
+local delayAfter = tonumber(ARGV[2])
+-- 最长等待时间，毫秒
+local maxDelay = tonumber(ARGV[3])
+
+local vals = redis.call("hmget", key, "fails", "last", "locked_until")
+local fails = tonumber(vals[1]) or 0
+local last = tonumber(vals[2]) or 0
+local lockedUntil = tonumber(vals[3]) or 0
+
+-- 返回值是 {状态, 还需要等待的毫秒数}
+
+if lockedUntil > now then
+    -- 已经被锁定了
+    return {-1, lockedUntil - now}
+end
+
+if fails >= delayAfter then
+    -- 失败次数越多，需要等待的时间越长，从 1 秒开始每次翻倍
+    local delay = math.min(1000 * 2 ^ (fails - delayAfter), maxDelay)
+    if last + delay > now then
+        return {-2, last + delay - now}
+    end
+end
+
+return {0, 0}
@@ -0,0 +1,22 @@
+-- 登录失败计数的 key，也就是 login_attempt:维度:账号或者IP
+local key = KEYS[1]
+-- 当前时间，毫秒
+local now = tonumber(ARGV[1])
+-- 失败多少次之后锁定
+local lockAfter = tonumber(ARGV[2])
+-- 锁定多久，毫秒
+local lockDuration = tonumber(ARGV[3])
+-- 计数的有效期，毫秒，超过这个时间没有再失败就重新计数
+local window = tonumber(ARGV[4])
+
+local fails = redis.call("hincrby", key, "fails", 1)
+redis.call("hset", key, "last", now)
+
+if fails >= lockAfter then
+    -- 锁定之后重新计数，解锁之后再失败还是从头开始延迟
+    redis.call("hset", key, "locked_until", now + lockDuration, "fails", 0)
+end
+
+-- 过期时间至少要覆盖锁定时间
+redis.call("pexpire", key, math.max(window, lockDuration))
+return fails
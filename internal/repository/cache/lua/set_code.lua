@@ -1,7 +1,7 @@
--- 发送到的 key，也就是是 code:业务:手机号码
+-- 发送到的 key，也就是是 phone_code:{业务:手机号码}
 local key = KEYS[1]
--- 使用次数，也就是验证次数
-local cntKey = key..":cnt"
+-- 使用次数，也就是验证次数，key 后面加上 :cnt
+local cntKey = KEYS[2]
 -- 你准备存储的验证码
 local val = ARGV[1]
 -- 验证码的有效时间是十分钟，600秒
@@ -0,0 +1,8 @@
+-- 锁的 key
+local key = KEYS[1]
+-- 加锁时候的 token，只能释放自己加的锁
+local token = ARGV[1]
+if redis.call("get", key) == token then
+    return redis.call("del", key)
+end
+return 0
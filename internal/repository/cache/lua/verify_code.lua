@@ -1,24 +1,36 @@
--- 发送到的 key，也就是是 code:业务:手机号码
+-- 发送到的 key，也就是是 phone_code:{业务:手机号码}
 local key = KEYS[1]
--- 使用次数，也就是验证次数
-local cntKey = key..":cnt"
+-- 使用次数，也就是验证次数，key 后面加上 :cnt
+local cntKey = KEYS[2]
 -- 用户输入的验证码
 local expectedCode = ARGV[1]
 
 local cnt = tonumber(redis.call("get", cntKey))
 local code = redis.call("get", key)
 
+-- 返回值是 {状态, 剩余验证次数}
+
+if code == false then
+    -- 验证码不存在，要么已经过期了，要么压根就没发送过
+    return {-3, 0}
+end
+
+if cnt == -1 then
+    -- 已经验证通过了，验证码只能用一次
+    return {-4, 0}
+end
+
 if cnt == nil or cnt <= 0 then
     -- 验证次数已经用完
-    return -1
+    return {-1, 0}
 end
 
 if code == expectedCode then
-    -- 验证码正确，验证次数就设置为 0
-    redis.call("set", cntKey, 0)
-    return 0
+    -- 验证码正确，验证次数设置为 -1，标记已经用过了，过期时间不变
+    redis.call("set", cntKey, -1, "KEEPTTL")
+    return {0, 0}
 else
     -- 验证码错误，可能是用户输入错误，验证次数减一
-    redis.call("decr", cntKey)
-    return -2
-end
\ No newline at end of file
+    cnt = redis.call("decr", cntKey)
+    return {-2, cnt}
+end
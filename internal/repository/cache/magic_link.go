@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	ErrMagicLinkSendTooMany = errors.New("登录链接发送太频繁")
+	ErrMagicLinkNotFound    = errors.New("登录链接不存在或者已经失效")
+)
+
+type MagicLinkCache interface {
+	// Set 保存 token 对应的邮箱，同一个邮箱在 interval 内只能发送一次
+	Set(ctx context.Context, tokenId, email string) error
+	// Consume 取出 token 对应的邮箱，取出之后 token 就失效了
+	Consume(ctx context.Context, tokenId string) (string, error)
+}
+
+type RedisMagicLinkCache struct {
+	cmd redis.Cmdable
+	// expiration 登录链接的有效期
+	expiration time.Duration
+	// interval 同一个邮箱两次发送之间的最小间隔
+	interval time.Duration
+}
+
+func NewMagicLinkCache(cmd redis.Cmdable) MagicLinkCache {
+	return &RedisMagicLinkCache{
+		cmd:        cmd,
+		expiration: time.Minute * 15,
+		interval:   time.Minute,
+	}
+}
+
+func (c *RedisMagicLinkCache) Set(ctx context.Context, tokenId, email string) error {
+	ok, err := c.cmd.SetNX(ctx, c.sendKey(email), tokenId, c.interval).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMagicLinkSendTooMany
+	}
+	return c.cmd.Set(ctx, c.key(tokenId), email, c.expiration).Err()
+}
+
+func (c *RedisMagicLinkCache) Consume(ctx context.Context, tokenId string) (string, error) {
+	// GETDEL 是原子操作，保证同一个 token 只能被用一次
+	email, err := c.cmd.GetDel(ctx, c.key(tokenId)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrMagicLinkNotFound
+	}
+	return email, err
+}
+
+func (c *RedisMagicLinkCache) key(tokenId string) string {
+	return fmt.Sprintf("magic_link:%s", tokenId)
+}
+
+func (c *RedisMagicLinkCache) sendKey(email string) string {
+	return fmt.Sprintf("magic_link:send:%s", email)
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"mini-ebook/internal/domain"
+	"strconv"
+)
+
+// userInvalidateChannel 用户缓存失效的广播频道
+const userInvalidateChannel = "user:info:invalidate"
+
+// TwoLevelUserCache 本地缓存 + Redis 缓存
+// 读的时候先读本地，再读 Redis；删除的时候通过 Redis pub/sub 通知所有实例删掉自己的本地缓存
+type TwoLevelUserCache struct {
+	local  UserCache
+	remote UserCache
+	client redis.UniversalClient
+}
+
+// NewTwoLevelUserCache 创建之后就开始订阅失效消息，直到 ctx 被取消
+func NewTwoLevelUserCache(ctx context.Context, local UserCache, remote UserCache, client redis.UniversalClient) UserCache {
+	c := &TwoLevelUserCache{
+		local:  local,
+		remote: remote,
+		client: client,
+	}
+	go c.subscribe(ctx)
+	return c
+}
+
+func (c *TwoLevelUserCache) Get(ctx context.Context, uid int64) (domain.User, error) {
+	u, err := c.local.Get(ctx, uid)
+	if err == nil || errors.Is(err, ErrUserNotExist) {
+		return u, err
+	}
+	u, err = c.remote.Get(ctx, uid)
+	if errors.Is(err, ErrUserNotExist) {
+		_ = c.local.SetNotFound(ctx, uid)
+		return domain.User{}, err
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	_ = c.local.Set(ctx, u)
+	return u, nil
+}
+
+func (c *TwoLevelUserCache) Set(ctx context.Context, du domain.User) error {
+	err := c.remote.Set(ctx, du)
+	if err != nil {
+		return err
+	}
+	return c.local.Set(ctx, du)
+}
+
+func (c *TwoLevelUserCache) SetNotFound(ctx context.Context, uid int64) error {
+	err := c.remote.SetNotFound(ctx, uid)
+	if err != nil {
+		return err
+	}
+	return c.local.SetNotFound(ctx, uid)
+}
+
+// Del 先删 Redis，再广播给所有实例（包括自己）删本地缓存
+// Redis 删失败了也要广播，不然其他实例的本地缓存要等过期才能删掉
+func (c *TwoLevelUserCache) Del(ctx context.Context, uid int64) error {
+	_ = c.local.Del(ctx, uid)
+	err := c.remote.Del(ctx, uid)
+	return errors.Join(err, c.client.Publish(ctx, userInvalidateChannel, uid).Err())
+}
+
+// subscribe 收到失效消息就删本地缓存
+// 断线的时候 go-redis 会自动重连，这期间漏掉的消息靠本地缓存的短过期时间兜底
+func (c *TwoLevelUserCache) subscribe(ctx context.Context) {
+	pubsub := c.client.Subscribe(ctx, userInvalidateChannel)
+	defer pubsub.Close()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			uid, err := strconv.ParseInt(msg.Payload, 10, 64)
+			if err != nil {
+				log.Printf("用户缓存失效消息格式不对 %s", msg.Payload)
+				continue
+			}
+			_ = c.local.Del(ctx, uid)
+		}
+	}
+}
@@ -2,55 +2,97 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"log"
+	"math/rand"
 	"mini-ebook/internal/domain"
 	"time"
 )
 
+// ErrUserNotExist 缓存里记录了这个用户不存在，不用再去查数据库
+var ErrUserNotExist = errors.New("用户不存在")
+
 type UserCache interface {
+	// Get 缓存里没有返回 ErrKeyNotExist，缓存了用户不存在返回 ErrUserNotExist
 	Get(ctx context.Context, uid int64) (domain.User, error)
 	Set(ctx context.Context, du domain.User) error
+	// SetNotFound 缓存用户不存在的结果，防止不存在的 id 每次都打到数据库
+	SetNotFound(ctx context.Context, uid int64) error
+	Del(ctx context.Context, uid int64) error
 }
 
+// notFoundPlaceholder 用户不存在的时候缓存的值，正常序列化出来的数据不可能是这个值
+const notFoundPlaceholder = "-"
+
 type RedisUserCache struct {
 	cmd        redis.Cmdable
+	codec      UserCodec
 	expiration time.Duration
+	// jitter 过期时间再随机加上 [0, jitter)，避免同一批写进去的 key 一起过期
+	jitter time.Duration
+	// notFoundExpiration 不存在的结果只缓存很短的时间
+	notFoundExpiration time.Duration
 }
 
-// NewUserCache 创建基于 Redis 的缓存
+// NewUserCache 创建基于 Redis 的缓存，默认用 msgpack 序列化
 func NewUserCache(cmd redis.Cmdable) UserCache {
+	return NewUserCacheWithCodec(cmd, MsgpackUserCodec{})
+}
+
+func NewUserCacheWithCodec(cmd redis.Cmdable, codec UserCodec) UserCache {
 	return &RedisUserCache{
-		cmd:        cmd,
-		expiration: time.Minute * 15,
+		cmd:                cmd,
+		codec:              codec,
+		expiration:         time.Minute * 15,
+		jitter:             time.Minute * 3,
+		notFoundExpiration: time.Minute,
 	}
 }
 
-// Get 获取缓存中的 User
+// Get 获取缓存中的 User，拿到的 User 没有密码哈希
 func (c RedisUserCache) Get(ctx context.Context, uid int64) (domain.User, error) {
 	key := c.key(uid)
 	// 读取缓存后反序列化
-	data, err := c.cmd.Get(ctx, key).Result()
+	data, err := c.cmd.Get(ctx, key).Bytes()
 	if err != nil {
 		return domain.User{}, err
 	}
+	if string(data) == notFoundPlaceholder {
+		return domain.User{}, ErrUserNotExist
+	}
 
-	var u domain.User
-	err = json.Unmarshal([]byte(data), &u)
-	return u, err
+	// 换了序列化方式或者升级了版本，旧的缓存解不出来，当作没有命中，重新从数据库加载
+	var e UserEntity
+	err = c.codec.Unmarshal(data, &e)
+	if err != nil || e.Version != userEntitySchemaVersion {
+		log.Printf("用户 %d 的缓存格式不兼容：%v", uid, err)
+		return domain.User{}, ErrKeyNotExist
+	}
+	return e.toDomain(), nil
 }
 
 // Set 将 User 序列化后设置到缓存中
 func (c RedisUserCache) Set(ctx context.Context, du domain.User) error {
 	key := c.key(du.Id)
 	// 序列化后缓存
-	data, err := json.Marshal(du)
+	data, err := c.codec.Marshal(newUserEntity(du))
 	if err != nil {
 		return err
 	}
 
-	return c.cmd.Set(ctx, key, data, c.expiration).Err()
+	expiration := c.expiration + time.Duration(rand.Int63n(int64(c.jitter)))
+	return c.cmd.Set(ctx, key, data, expiration).Err()
+}
+
+func (c RedisUserCache) SetNotFound(ctx context.Context, uid int64) error {
+	return c.cmd.Set(ctx, c.key(uid), notFoundPlaceholder, c.notFoundExpiration).Err()
+}
+
+// Del 删除缓存中的 User
+func (c RedisUserCache) Del(ctx context.Context, uid int64) error {
+	return c.cmd.Del(ctx, c.key(uid)).Err()
 }
 
 // key 读写 RedisUserCache 缓存时候的键
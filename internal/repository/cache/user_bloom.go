@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+)
+
+var (
+	//go:embed lua/bloom_add.lua
+	luaBloomAdd string
+	//go:embed lua/bloom_check.lua
+	luaBloomCheck string
+)
+
+// UserBloomFilter 记录所有存在的用户 id
+// 说不存在就一定不存在，说存在有一定概率是误判
+type UserBloomFilter interface {
+	Add(ctx context.Context, uid int64) error
+	MightContain(ctx context.Context, uid int64) (bool, error)
+}
+
+// RedisUserBloomFilter 用 Redis 的 bitmap 实现，所有实例共享，不依赖 RedisBloom 模块
+type RedisUserBloomFilter struct {
+	cmd    redis.Cmdable
+	key    string
+	bits   uint64
+	hashes int
+}
+
+// NewUserBloomFilter bits 是 bitmap 的大小，hashes 是哈希函数的个数
+// 一千万用户、误判率 1% 左右，大概需要 1 亿个 bit（12MB）和 7 个哈希函数
+func NewUserBloomFilter(cmd redis.Cmdable, bits uint64, hashes int) UserBloomFilter {
+	return &RedisUserBloomFilter{
+		cmd:    cmd,
+		key:    "user:bloom",
+		bits:   bits,
+		hashes: hashes,
+	}
+}
+
+func (b *RedisUserBloomFilter) Add(ctx context.Context, uid int64) error {
+	return b.cmd.Eval(ctx, luaBloomAdd, []string{b.key}, b.offsets(uid)...).Err()
+}
+
+func (b *RedisUserBloomFilter) MightContain(ctx context.Context, uid int64) (bool, error) {
+	res, err := b.cmd.Eval(ctx, luaBloomCheck, []string{b.key}, b.offsets(uid)...).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// offsets 用两个哈希值组合出 hashes 个位置（Kirsch-Mitzenmacher）
+func (b *RedisUserBloomFilter) offsets(uid int64) []any {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(uid))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	h1 := h.Sum64()
+	h.Write(buf[:])
+	h2 := h.Sum64() | 1
+
+	res := make([]any, 0, b.hashes)
+	for i := 0; i < b.hashes; i++ {
+		res = append(res, (h1+uint64(i)*h2)%b.bits)
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/vmihailenco/msgpack/v5"
+	"mini-ebook/internal/domain"
+	"time"
+)
+
+// userEntitySchemaVersion UserEntity 有不兼容的修改就加一，旧版本的缓存会被当成没有命中
+// 2：加了 ProfileVersion，旧的缓存里读出来是 0，会导致修改资料一直冲突
+const userEntitySchemaVersion = 2
+
+// UserEntity 缓存里的用户
+// 只放展示和校验 token 需要的字段，密码哈希之类的敏感字段不进缓存
+type UserEntity struct {
+	Version        int    `json:"v" msgpack:"v"`
+	Id             int64  `json:"id" msgpack:"id"`
+	Email          string `json:"email,omitempty" msgpack:"email,omitempty"`
+	Phone          string `json:"phone,omitempty" msgpack:"phone,omitempty"`
+	Nickname       string `json:"nickname,omitempty" msgpack:"nickname,omitempty"`
+	Birthday       int64  `json:"birthday,omitempty" msgpack:"birthday,omitempty"`
+	AboutMe        string `json:"aboutMe,omitempty" msgpack:"aboutMe,omitempty"`
+	Avatar         string `json:"avatar,omitempty" msgpack:"avatar,omitempty"`
+	TokenVersion   int64  `json:"tokenVersion,omitempty" msgpack:"tokenVersion,omitempty"`
+	Ctime          int64  `json:"ctime" msgpack:"ctime"`
+	Utime          int64  `json:"utime" msgpack:"utime"`
+	HideBirthday   bool   `json:"hideBirthday,omitempty" msgpack:"hideBirthday,omitempty"`
+	HideAboutMe    bool   `json:"hideAboutMe,omitempty" msgpack:"hideAboutMe,omitempty"`
+	HideFromSearch bool   `json:"hideFromSearch,omitempty" msgpack:"hideFromSearch,omitempty"`
+	Role           uint8  `json:"role,omitempty" msgpack:"role,omitempty"`
+	// ProfileVersion 资料的版本号，Version 已经是缓存格式的版本号了
+	ProfileVersion int64 `json:"profileVersion,omitempty" msgpack:"profileVersion,omitempty"`
+}
+
+func newUserEntity(u domain.User) UserEntity {
+	return UserEntity{
+		Version:        userEntitySchemaVersion,
+		Id:             u.Id,
+		Email:          u.Email,
+		Phone:          u.Phone,
+		Nickname:       u.Nickname,
+		Birthday:       domain.BirthdayToMillis(u.Birthday),
+		AboutMe:        u.AboutMe,
+		Avatar:         u.Avatar,
+		TokenVersion:   u.TokenVersion,
+		Ctime:          u.Ctime.UnixMilli(),
+		Utime:          u.Utime.UnixMilli(),
+		HideBirthday:   u.Privacy.HideBirthday,
+		HideAboutMe:    u.Privacy.HideAboutMe,
+		HideFromSearch: u.Privacy.HideFromSearch,
+		Role:           uint8(u.Role),
+		ProfileVersion: u.Version,
+	}
+}
+
+func (e UserEntity) toDomain() domain.User {
+	return domain.User{
+		Id:           e.Id,
+		Email:        e.Email,
+		Phone:        e.Phone,
+		Nickname:     e.Nickname,
+		Birthday:     domain.BirthdayFromMillis(e.Birthday),
+		AboutMe:      e.AboutMe,
+		Avatar:       e.Avatar,
+		TokenVersion: e.TokenVersion,
+		Ctime:        time.UnixMilli(e.Ctime),
+		Utime:        time.UnixMilli(e.Utime),
+		Privacy: domain.PrivacySettings{
+			HideBirthday:   e.HideBirthday,
+			HideAboutMe:    e.HideAboutMe,
+			HideFromSearch: e.HideFromSearch,
+		},
+		Role:    domain.UserRole(e.Role),
+		Version: e.ProfileVersion,
+	}
+}
+
+// UserCodec 缓存里用户的序列化方式
+type UserCodec interface {
+	Marshal(e UserEntity) ([]byte, error)
+	Unmarshal(data []byte, e *UserEntity) error
+}
+
+// JSONUserCodec 可读性好，方便排查问题
+type JSONUserCodec struct{}
+
+func (JSONUserCodec) Marshal(e UserEntity) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (JSONUserCodec) Unmarshal(data []byte, e *UserEntity) error {
+	return json.Unmarshal(data, e)
+}
+
+// MsgpackUserCodec 比 JSON 更省内存，编解码也更快
+type MsgpackUserCodec struct{}
+
+func (MsgpackUserCodec) Marshal(e UserEntity) ([]byte, error) {
+	return msgpack.Marshal(e)
+}
+
+func (MsgpackUserCodec) Unmarshal(data []byte, e *UserEntity) error {
+	return msgpack.Unmarshal(data, e)
+}
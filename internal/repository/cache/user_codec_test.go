@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"bytes"
+	"mini-ebook/internal/domain"
+	"testing"
+	"time"
+)
+
+func TestUserCodec(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	u := domain.User{
+		Id:           1,
+		Email:        "a@qq.com",
+		Password:     "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		Nickname:     "大明",
+		Birthday:     now,
+		TokenVersion: 3,
+		Version:      2,
+		Ctime:        now,
+		Utime:        now,
+		Privacy:      domain.PrivacySettings{HideAboutMe: true},
+		Role:         domain.UserRoleAdmin,
+	}
+	for name, codec := range map[string]UserCodec{"json": JSONUserCodec{}, "msgpack": MsgpackUserCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Marshal(newUserEntity(u))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte("argon2id")) {
+				t.Fatal("密码哈希不应该进缓存")
+			}
+			var e UserEntity
+			if err = codec.Unmarshal(data, &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Version != userEntitySchemaVersion {
+				t.Fatalf("期望版本 %d，实际 %d", userEntitySchemaVersion, e.Version)
+			}
+			want := u
+			want.Password = ""
+			got := e.toDomain()
+			if got != want {
+				t.Fatalf("期望 %+v，实际 %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestUserCodec_NoBirthday(t *testing.T) {
+	// 没有填生日不能变成 1970-01-01
+	e := newUserEntity(domain.User{Id: 1})
+	if e.Birthday != 0 {
+		t.Fatalf("没有填生日期望存 0，实际 %d", e.Birthday)
+	}
+	if got := e.toDomain(); !got.Birthday.IsZero() {
+		t.Fatalf("没有填生日期望零值，实际 %v", got.Birthday)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+//go:embed lua/unlock.lua
+var luaUnlock string
+
+// UserRebuildLock 缓存失效的时候，多个实例之间只让一个去数据库重建缓存
+type UserRebuildLock interface {
+	// TryLock 不等待，拿不到锁返回 false
+	TryLock(ctx context.Context, uid int64) (token string, ok bool, err error)
+	Unlock(ctx context.Context, uid int64, token string) error
+}
+
+type RedisUserRebuildLock struct {
+	cmd redis.Cmdable
+	// expiration 持有锁的实例挂了，锁也会自动释放
+	expiration time.Duration
+}
+
+func NewUserRebuildLock(cmd redis.Cmdable) UserRebuildLock {
+	return &RedisUserRebuildLock{
+		cmd:        cmd,
+		expiration: time.Second * 3,
+	}
+}
+
+func (l *RedisUserRebuildLock) TryLock(ctx context.Context, uid int64) (string, bool, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(buf)
+	ok, err := l.cmd.SetNX(ctx, l.key(uid), token, l.expiration).Result()
+	return token, ok, err
+}
+
+func (l *RedisUserRebuildLock) Unlock(ctx context.Context, uid int64, token string) error {
+	return l.cmd.Eval(ctx, luaUnlock, []string{l.key(uid)}, token).Err()
+}
+
+func (l *RedisUserRebuildLock) key(uid int64) string {
+	return fmt.Sprintf("user:info:lock:%d", uid)
+}
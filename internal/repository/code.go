@@ -2,15 +2,15 @@ package repository
 
 import (
 	"context"
+	"mini-ebook/internal/domain"
 	"mini-ebook/internal/repository/cache"
 )
 
-var ErrCodeVerifyTooMany = cache.ErrCodeVerifyToMany
 var ErrCodeSendTooMany = cache.ErrCodeSendToMany
 
 type CodeRepository interface {
 	Set(ctx context.Context, biz, phone, code string) error
-	Verify(ctx context.Context, biz, phone, code string) (bool, error)
+	Verify(ctx context.Context, biz, phone, code string) (domain.CodeVerifyResult, error)
 }
 
 type CachedCodeRepository struct {
@@ -27,6 +27,6 @@ func (c *CachedCodeRepository) Set(ctx context.Context, biz, phone, code string)
 	return c.cache.Set(ctx, biz, phone, code)
 }
 
-func (c *CachedCodeRepository) Verify(ctx context.Context, biz, phone, code string) (bool, error) {
+func (c *CachedCodeRepository) Verify(ctx context.Context, biz, phone, code string) (domain.CodeVerifyResult, error) {
 	return c.cache.Verify(ctx, biz, phone, code)
 }
@@ -0,0 +1,29 @@
+package dao
+
+import (
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"mini-ebook/pkg/migrator"
+	"path"
+)
+
+// migrationFS 表结构的变更都要在 migrations 目录下新增一对 up/down 文件，不要再改已经发布的文件
+// 每种数据库一个子目录，版本号要一一对应
+//
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql
+var migrationFS embed.FS
+
+// NewMigrator 根据 db 的方言选择对应目录下的迁移文件
+func NewMigrator(db *gorm.DB) (*migrator.Migrator, error) {
+	dir := path.Join("migrations", db.Dialector.Name())
+	if _, err := fs.Stat(migrationFS, dir); err != nil {
+		return nil, fmt.Errorf("不支持的数据库 %s", db.Dialector.Name())
+	}
+	sub, err := fs.Sub(migrationFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	return migrator.New(db, sub)
+}
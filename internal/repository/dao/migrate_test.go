@@ -0,0 +1,105 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"mini-ebook/pkg/migrator"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrations_DownAndUp 每个迁移都能回滚，回滚之后还能重新执行
+func TestMigrations_DownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = m.Down(ctx)
+		if errors.Is(err, migrator.ErrNoApplied) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.Migrator().HasTable("users") {
+		t.Fatal("全部回滚之后不应该还有 users 表")
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasColumn(&User{}, "version") {
+		t.Fatal("重新执行之后应该有 version 列")
+	}
+}
+
+// baselineUser 引入迁移之前 AutoMigrate 建出来的 users 表
+type baselineUser struct {
+	Email    sql.NullString `gorm:"unique"`
+	Phone    sql.NullString `gorm:"unique"`
+	Password string
+	Ctime    int64
+	Utime    int64
+	Id       int64  `gorm:"PrimaryKey,autoIncrement"`
+	Nickname string `gorm:"type=varchar(128)"`
+	Birthday int64
+	AboutMe  string `gorm:"type=varchar(4096)"`
+}
+
+func (baselineUser) TableName() string {
+	return "users"
+}
+
+// TestMigrations_UpOnBaseline 已经有老 users 表的库执行迁移之后，要补齐所有新加的列和索引，老数据还能查出来
+func TestMigrations_UpOnBaseline(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&baselineUser{}); err != nil {
+		t.Fatal(err)
+	}
+	old := baselineUser{
+		Email:    sql.NullString{String: "old@example.com", Valid: true},
+		Password: "hash",
+		Ctime:    1,
+		Utime:    1,
+		Nickname: "old",
+	}
+	if err = db.Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, col := range []string{"avatar", "hide_birthday", "hide_about_me", "hide_from_search",
+		"role", "token_version", "deleted_at", "version"} {
+		if !db.Migrator().HasColumn(&User{}, col) {
+			t.Fatalf("执行迁移之后应该有 %s 列", col)
+		}
+	}
+	for _, idx := range []string{"idx_users_ctime", "idx_users_deleted_at"} {
+		if !db.Migrator().HasIndex(&User{}, idx) {
+			t.Fatalf("执行迁移之后应该有 %s 索引", idx)
+		}
+	}
+	var u User
+	if err = db.Where("email = ?", "old@example.com").First(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.Id != old.Id || u.Nickname != "old" || u.DeletedAt.Valid {
+		t.Fatalf("老数据不对：%+v", u)
+	}
+}
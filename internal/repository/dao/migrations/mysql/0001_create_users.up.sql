@@ -0,0 +1,16 @@
+-- 和之前 AutoMigrate 建出来的表结构一致，已经有这张表的库直接跳过
+-- 之后新增的列都放在单独的 ALTER TABLE 迁移里，这样老库和新库执行完的结果是一样的
+CREATE TABLE IF NOT EXISTS `users` (
+    `email`    varchar(191) DEFAULT NULL,
+    `phone`    varchar(191) DEFAULT NULL,
+    `password` longtext,
+    `ctime`    bigint DEFAULT NULL,
+    `utime`    bigint DEFAULT NULL,
+    `id`       bigint NOT NULL AUTO_INCREMENT,
+    `nickname` longtext,
+    `birthday` bigint DEFAULT NULL,
+    `about_me` longtext,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `email` (`email`),
+    UNIQUE KEY `phone` (`phone`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
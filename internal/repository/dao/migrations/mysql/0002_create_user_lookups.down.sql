@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `user_phones`;
+DROP TABLE IF EXISTS `user_emails`;
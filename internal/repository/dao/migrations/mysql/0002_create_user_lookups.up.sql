@@ -0,0 +1,16 @@
+-- 用户分库之后按邮箱、手机号找用户 id 的索引表，只在主库上使用，主键保证邮箱和手机号全局唯一
+CREATE TABLE IF NOT EXISTS `user_emails` (
+    `email` varchar(191) NOT NULL,
+    `uid`   bigint NOT NULL,
+    `ctime` bigint NOT NULL,
+    PRIMARY KEY (`email`),
+    KEY `idx_user_emails_uid` (`uid`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `user_phones` (
+    `phone` varchar(191) NOT NULL,
+    `uid`   bigint NOT NULL,
+    `ctime` bigint NOT NULL,
+    PRIMARY KEY (`phone`),
+    KEY `idx_user_phones_uid` (`uid`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,2 @@
+-- 资料的版本号，修改资料的时候做乐观锁
+ALTER TABLE `users` ADD COLUMN `version` bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+ALTER TABLE `users`
+    DROP KEY `idx_users_deleted_at`,
+    DROP KEY `idx_users_ctime`,
+    DROP COLUMN `deleted_at`,
+    DROP COLUMN `token_version`,
+    DROP COLUMN `role`,
+    DROP COLUMN `hide_from_search`,
+    DROP COLUMN `hide_about_me`,
+    DROP COLUMN `hide_birthday`,
+    DROP COLUMN `avatar`;
@@ -0,0 +1,11 @@
+-- 建表迁移之后陆续加到 User 上的列，之前靠 AutoMigrate 补上，老库要在这里补齐
+ALTER TABLE `users`
+    ADD COLUMN `avatar`           varchar(1024) DEFAULT NULL,
+    ADD COLUMN `hide_birthday`    tinyint(1) NOT NULL DEFAULT 0,
+    ADD COLUMN `hide_about_me`    tinyint(1) NOT NULL DEFAULT 0,
+    ADD COLUMN `hide_from_search` tinyint(1) NOT NULL DEFAULT 0,
+    ADD COLUMN `role`             tinyint unsigned NOT NULL DEFAULT 0,
+    ADD COLUMN `token_version`    bigint NOT NULL DEFAULT 0,
+    ADD COLUMN `deleted_at`       datetime(3) DEFAULT NULL,
+    ADD KEY `idx_users_ctime` (`ctime`),
+    ADD KEY `idx_users_deleted_at` (`deleted_at`);
@@ -0,0 +1,14 @@
+-- 和 mysql 目录下的同名迁移对应，本地开发和测试用
+CREATE TABLE IF NOT EXISTS `users` (
+    `id`       integer PRIMARY KEY AUTOINCREMENT,
+    `email`    varchar(191) DEFAULT NULL,
+    `phone`    varchar(191) DEFAULT NULL,
+    `password` text,
+    `ctime`    integer DEFAULT NULL,
+    `utime`    integer DEFAULT NULL,
+    `nickname` text,
+    `birthday` integer DEFAULT NULL,
+    `about_me` text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_email` ON `users` (`email`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_phone` ON `users` (`phone`);
@@ -0,0 +1,14 @@
+-- 和 mysql 目录下的同名迁移对应
+CREATE TABLE IF NOT EXISTS `user_emails` (
+    `email` varchar(191) NOT NULL PRIMARY KEY,
+    `uid`   integer NOT NULL,
+    `ctime` integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_user_emails_uid` ON `user_emails` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `user_phones` (
+    `phone` varchar(191) NOT NULL PRIMARY KEY,
+    `uid`   integer NOT NULL,
+    `ctime` integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_user_phones_uid` ON `user_phones` (`uid`);
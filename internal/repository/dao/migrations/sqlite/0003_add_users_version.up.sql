@@ -0,0 +1,2 @@
+-- 和 mysql 目录下的同名迁移对应
+ALTER TABLE `users` ADD COLUMN `version` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+-- 和 mysql 目录下的同名迁移对应，SQLite 删列之前要先删掉列上的索引
+DROP INDEX IF EXISTS `idx_users_deleted_at`;
+DROP INDEX IF EXISTS `idx_users_ctime`;
+ALTER TABLE `users` DROP COLUMN `deleted_at`;
+ALTER TABLE `users` DROP COLUMN `token_version`;
+ALTER TABLE `users` DROP COLUMN `role`;
+ALTER TABLE `users` DROP COLUMN `hide_from_search`;
+ALTER TABLE `users` DROP COLUMN `hide_about_me`;
+ALTER TABLE `users` DROP COLUMN `hide_birthday`;
+ALTER TABLE `users` DROP COLUMN `avatar`;
@@ -0,0 +1,10 @@
+-- 和 mysql 目录下的同名迁移对应，SQLite 一条 ALTER TABLE 只能加一列
+ALTER TABLE `users` ADD COLUMN `avatar` varchar(1024) DEFAULT NULL;
+ALTER TABLE `users` ADD COLUMN `hide_birthday` numeric NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `hide_about_me` numeric NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `hide_from_search` numeric NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `role` integer NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `token_version` integer NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `deleted_at` datetime DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS `idx_users_ctime` ON `users` (`ctime`);
+CREATE INDEX IF NOT EXISTS `idx_users_deleted_at` ON `users` (`deleted_at`);
@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// NormalizePhones 一次性的数据迁移：把 users 表里已有的手机号码统一转成 normalize 之后的格式
+// 格式化之后和已有的号码冲突的（也就是同一个人注册了两个账号），只打日志跳过，留给人工处理
+// 返回成功更新的行数
+func NormalizePhones(ctx context.Context, db *gorm.DB, normalize func(raw string) (string, error)) (int, error) {
+	const batchSize = 100
+	var (
+		lastId  int64
+		updated int
+	)
+	for {
+		var users []User
+		err := db.WithContext(ctx).
+			Where("id > ? AND phone IS NOT NULL", lastId).
+			Order("id").Limit(batchSize).Find(&users).Error
+		if err != nil {
+			return updated, err
+		}
+		if len(users) == 0 {
+			return updated, nil
+		}
+		lastId = users[len(users)-1].Id
+
+		for _, u := range users {
+			number, err := normalize(u.Phone.String)
+			if err != nil {
+				log.Printf("用户 %d 的手机号码 %s 不合法：%v", u.Id, u.Phone.String, err)
+				continue
+			}
+			if number == u.Phone.String {
+				continue
+			}
+
+			err = db.WithContext(ctx).Model(&User{}).Where("id = ?", u.Id).
+				Updates(map[string]any{
+					"phone": sql.NullString{String: number, Valid: true},
+					"utime": time.Now().UnixMilli(),
+				}).Error
+			if errors.Is(translateDuplicateErr(db, err), ErrDuplicateUser) {
+				log.Printf("用户 %d 的手机号码 %s 格式化后和已有用户冲突", u.Id, number)
+				continue
+			}
+			if err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package dao
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"log"
+	"sort"
+	"time"
+)
+
+// contactLookupTables 邮箱、手机号对应的索引表，key 是 users 表里的列名
+var contactLookupTables = map[string]string{
+	"email": "user_emails",
+	"phone": "user_phones",
+}
+
+// ShardedUserDAO 用户按 id 分库，每个库里都是一张完整的 users 表，表结构由同一套迁移文件维护
+// 邮箱和手机号不是分片键，另外在 index 库里用 user_emails、user_phones 两张索引表记录它们属于哪个用户，
+// 全局唯一也靠这两张表的主键保证。
+//
+// 跨库没有事务：修改邮箱、手机号的时候先占索引再改用户，改失败了再把索引还回去。
+// 进程在中间挂了会留下对不上的索引，下次有人要用这个邮箱或者手机号的时候会被识别出来并回收。
+type ShardedUserDAO struct {
+	index  *gorm.DB
+	shards []*GORMUserDao
+	ids    IdGenerator
+	// staleLookupAfter 索引写进去超过这么久，对应的用户还是对不上，才认为是脏数据，避免回收别人正在注册的索引
+	staleLookupAfter time.Duration
+}
+
+// NewShardedUserDAO index 是放索引表的库，shards 的顺序决定了用户在哪个库，调整的时候要用 ReshardUsers 搬数据
+func NewShardedUserDAO(index *gorm.DB, shards []*gorm.DB, ids IdGenerator) UserDAO {
+	res := &ShardedUserDAO{
+		index:            index,
+		shards:           make([]*GORMUserDao, 0, len(shards)),
+		ids:              ids,
+		staleLookupAfter: time.Minute,
+	}
+	for _, db := range shards {
+		res.shards = append(res.shards, &GORMUserDao{db: db})
+	}
+	return res
+}
+
+// indexWithCtx 和 GORMUserDao.dbWithCtx 一样，ctx 里要求了走主库的话索引表也要查主库
+func (s *ShardedUserDAO) indexWithCtx(ctx context.Context) *gorm.DB {
+	return withCtx(s.index, ctx)
+}
+
+// shardOf 对 id 哈希之后再取模，snowflake id 的低位是序列号，大部分时候是 0，直接取模会很不均匀
+func shardOf(uid int64, n int) int {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(uid))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return int(h.Sum64() % uint64(n))
+}
+
+func (s *ShardedUserDAO) shard(uid int64) *GORMUserDao {
+	return s.shards[shardOf(uid, len(s.shards))]
+}
+
+func (s *ShardedUserDAO) Insert(ctx context.Context, u User) (int64, error) {
+	id, err := s.ids.Next()
+	if err != nil {
+		return 0, err
+	}
+	u.Id = id
+	cs := contactsOf(u)
+	for i, c := range cs {
+		if err = s.claimLookup(ctx, c, id); err != nil {
+			s.releaseLookups(ctx, cs[:i], id)
+			return 0, err
+		}
+	}
+	if err = s.shard(id).insert(ctx, u); err != nil {
+		s.releaseLookups(ctx, cs, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *ShardedUserDAO) FindById(ctx context.Context, uid int64) (User, error) {
+	return s.shard(uid).FindById(ctx, uid)
+}
+
+func (s *ShardedUserDAO) FindByEmail(ctx context.Context, email string) (User, error) {
+	return s.findByContact(ctx, contact{column: "email", val: email})
+}
+
+func (s *ShardedUserDAO) FindByPhone(ctx context.Context, phone string) (User, error) {
+	return s.findByContact(ctx, contact{column: "phone", val: phone})
+}
+
+func (s *ShardedUserDAO) UpdateByUserId(ctx context.Context, entity User) error {
+	return s.shard(entity.Id).UpdateByUserId(ctx, entity)
+}
+
+func (s *ShardedUserDAO) UpdatePassword(ctx context.Context, uid int64, password string) error {
+	return s.shard(uid).UpdatePassword(ctx, uid, password)
+}
+
+func (s *ShardedUserDAO) RehashPassword(ctx context.Context, uid int64, oldHash string, newHash string) error {
+	return s.shard(uid).RehashPassword(ctx, uid, oldHash, newHash)
+}
+
+func (s *ShardedUserDAO) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	return s.shard(uid).UpdateAvatar(ctx, uid, avatar)
+}
+
+func (s *ShardedUserDAO) UpdatePrivacy(ctx context.Context, entity User) error {
+	return s.shard(entity.Id).UpdatePrivacy(ctx, entity)
+}
+
+func (s *ShardedUserDAO) UpdatePhone(ctx context.Context, uid int64, phone string) error {
+	return s.changeContact(ctx, uid, contact{column: "phone", val: phone}, false)
+}
+
+func (s *ShardedUserDAO) UpdateEmail(ctx context.Context, uid int64, email string) error {
+	return s.changeContact(ctx, uid, contact{column: "email", val: email}, false)
+}
+
+func (s *ShardedUserDAO) BindPhone(ctx context.Context, uid int64, phone string) error {
+	return s.changeContact(ctx, uid, contact{column: "phone", val: phone}, true)
+}
+
+func (s *ShardedUserDAO) BindEmail(ctx context.Context, uid int64, email string) error {
+	return s.changeContact(ctx, uid, contact{column: "email", val: email}, true)
+}
+
+// changeContact 先占新的索引，改成功了再释放旧的索引
+func (s *ShardedUserDAO) changeContact(ctx context.Context, uid int64, c contact, bindOnly bool) error {
+	sh := s.shard(uid)
+	u, err := sh.FindById(ForcePrimary(ctx), uid)
+	if err != nil {
+		return err
+	}
+	old := c.of(u)
+	if bindOnly && old != "" {
+		return ErrContactAlreadyBound
+	}
+	if err = s.claimLookup(ctx, c, uid); err != nil {
+		return err
+	}
+	if bindOnly {
+		err = sh.bind(ctx, uid, c.column, c.val)
+	} else {
+		err = sh.updateContact(ctx, uid, c.column, c.val)
+	}
+	if err != nil {
+		if old != c.val {
+			s.releaseLookups(ctx, []contact{c}, uid)
+		}
+		return err
+	}
+	if old != "" && old != c.val {
+		s.releaseLookups(ctx, []contact{{column: c.column, val: old}}, uid)
+	}
+	return nil
+}
+
+// Delete 软删除会清空邮箱和手机号，对应的索引也要释放
+func (s *ShardedUserDAO) Delete(ctx context.Context, uid int64) error {
+	sh := s.shard(uid)
+	u, err := sh.FindById(ForcePrimary(ctx), uid)
+	if err != nil {
+		return err
+	}
+	if err = sh.Delete(ctx, uid); err != nil {
+		return err
+	}
+	s.releaseLookups(ctx, contactsOf(u), uid)
+	return nil
+}
+
+// Merge 两个账号在同一个库的时候直接用事务合并；不在同一个库的时候先更新 target 再删除 source，
+// 中途失败了重新合并一次，结果是一样的
+func (s *ShardedUserDAO) Merge(ctx context.Context, targetId int64, sourceId int64) error {
+	if targetId == sourceId {
+		return ErrMergeConflict
+	}
+	ctx = ForcePrimary(ctx)
+	ts, ss := s.shard(targetId), s.shard(sourceId)
+	target, err := ts.FindById(ctx, targetId)
+	if err != nil {
+		return err
+	}
+	source, err := ss.FindById(ctx, sourceId)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeUser(target, source)
+	if err != nil {
+		return err
+	}
+
+	// 先把 source 的索引指向 target，这样中途失败的时候，索引指向的用户也还存在
+	moved := contactsOf(source)
+	if err = s.repointLookups(ctx, moved, sourceId, targetId); err != nil {
+		return err
+	}
+	if ts == ss {
+		err = ts.Merge(ctx, targetId, sourceId)
+	} else {
+		err = ts.mergeInto(ctx, target, merged)
+	}
+	if err != nil {
+		if rerr := s.repointLookups(ctx, moved, targetId, sourceId); rerr != nil {
+			log.Printf("合并用户 %d 和 %d 失败之后还原索引失败 %v", targetId, sourceId, rerr)
+		}
+		return err
+	}
+	if ts != ss {
+		// target 已经带上了 source 的邮箱、手机号，索引不能再还回去，删除失败的话重新合并一次就好
+		return ss.hardDelete(ctx, sourceId)
+	}
+	return nil
+}
+
+// Search 每个库都查 Limit 条，合并排序之后再取前 Limit 条，keyset 分页的游标在所有库上都适用
+func (s *ShardedUserDAO) Search(ctx context.Context, q UserSearch) ([]User, error) {
+	var res []User
+	for _, sh := range s.shards {
+		users, err := sh.Search(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, users...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if q.Desc {
+			a, b = b, a
+		}
+		if q.SortByCtime && a.Ctime != b.Ctime {
+			return a.Ctime < b.Ctime
+		}
+		return a.Id < b.Id
+	})
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+func (s *ShardedUserDAO) PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]User, error) {
+	var res []User
+	for _, sh := range s.shards {
+		if len(res) >= limit {
+			break
+		}
+		users, err := sh.PurgeDeleted(ctx, before, limit-len(res))
+		res = append(res, users...)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// contact 用户的一个邮箱或者手机号
+type contact struct {
+	column string
+	val    string
+}
+
+func (c contact) table() string {
+	return contactLookupTables[c.column]
+}
+
+// of 用户在这一列上的值，为 NULL 的时候返回空字符串
+func (c contact) of(u User) string {
+	switch c.column {
+	case "email":
+		return u.Email.String
+	case "phone":
+		return u.Phone.String
+	}
+	return ""
+}
+
+func contactsOf(u User) []contact {
+	var res []contact
+	if u.Email.Valid {
+		res = append(res, contact{column: "email", val: u.Email.String})
+	}
+	if u.Phone.Valid {
+		res = append(res, contact{column: "phone", val: u.Phone.String})
+	}
+	return res
+}
+
+// contactLookup 索引表里的一行
+type contactLookup struct {
+	Uid   int64
+	Ctime int64
+}
+
+func (s *ShardedUserDAO) findLookup(ctx context.Context, c contact) (contactLookup, error) {
+	var res contactLookup
+	err := s.indexWithCtx(ctx).Table(c.table()).Select("uid", "ctime").
+		Where(c.column+" = ?", c.val).Take(&res).Error
+	return res, err
+}
+
+// findByContact 先查索引表拿到 id，再去对应的库里查用户；用户身上的值对不上说明索引是脏数据
+func (s *ShardedUserDAO) findByContact(ctx context.Context, c contact) (User, error) {
+	l, err := s.findLookup(ctx, c)
+	if err != nil {
+		return User{}, err
+	}
+	u, err := s.shard(l.Uid).FindById(ctx, l.Uid)
+	if err != nil {
+		return User{}, err
+	}
+	if c.of(u) != c.val {
+		return User{}, ErrRecordNotFound
+	}
+	return u, nil
+}
+
+// claimLookup 占用索引，已经被别人占了返回 ErrDuplicateUser，被自己占了直接返回
+func (s *ShardedUserDAO) claimLookup(ctx context.Context, c contact, uid int64) error {
+	now := time.Now().UnixMilli()
+	err := s.indexWithCtx(ctx).Table(c.table()).Create(map[string]any{
+		c.column: c.val,
+		"uid":    uid,
+		"ctime":  now,
+	}).Error
+	if err = translateDuplicateErr(s.index, err); !errors.Is(err, ErrDuplicateUser) {
+		return err
+	}
+
+	l, err := s.findLookup(ctx, c)
+	if errors.Is(err, ErrRecordNotFound) {
+		// 刚好被释放了，让调用方重试
+		return ErrDuplicateUser
+	}
+	if err != nil {
+		return err
+	}
+	if l.Uid == uid {
+		return nil
+	}
+	if !s.isStale(ctx, c, l) {
+		return ErrDuplicateUser
+	}
+	// 带上原来的 uid 做条件，两个请求同时回收的时候只有一个能成功
+	res := s.indexWithCtx(ctx).Table(c.table()).
+		Where(c.column+" = ? AND uid = ?", c.val, l.Uid).
+		Updates(map[string]any{"uid": uid, "ctime": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrDuplicateUser
+	}
+	return nil
+}
+
+// isStale 索引指向的用户不存在或者已经不用这个值了
+func (s *ShardedUserDAO) isStale(ctx context.Context, c contact, l contactLookup) bool {
+	if time.Since(time.UnixMilli(l.Ctime)) < s.staleLookupAfter {
+		return false
+	}
+	u, err := s.shard(l.Uid).FindById(ForcePrimary(ctx), l.Uid)
+	if errors.Is(err, ErrRecordNotFound) {
+		return true
+	}
+	return err == nil && c.of(u) != c.val
+}
+
+// releaseLookups 释放自己占用的索引，失败了只打日志，留下的脏数据下次会被回收
+func (s *ShardedUserDAO) releaseLookups(ctx context.Context, cs []contact, uid int64) {
+	for _, c := range cs {
+		err := s.indexWithCtx(ctx).
+			Exec("DELETE FROM "+c.table()+" WHERE "+c.column+" = ? AND uid = ?", c.val, uid).Error
+		if err != nil {
+			log.Printf("释放用户 %d 的索引 %s 失败 %v", uid, c.val, err)
+		}
+	}
+}
+
+// repointLookups 把 from 占用的索引转给 to，同时刷新时间，避免转移的过程中被当成脏数据回收
+func (s *ShardedUserDAO) repointLookups(ctx context.Context, cs []contact, from int64, to int64) error {
+	for _, c := range cs {
+		err := s.indexWithCtx(ctx).Table(c.table()).
+			Where(c.column+" = ? AND uid = ?", c.val, from).
+			Updates(map[string]any{"uid": to, "ctime": time.Now().UnixMilli()}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
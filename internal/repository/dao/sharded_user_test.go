@@ -0,0 +1,369 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"testing"
+	"time"
+)
+
+func newTestShardedUserDAO(t *testing.T, n int) (*ShardedUserDAO, *gorm.DB, []*gorm.DB) {
+	index := openTestDB(t)
+	shards := make([]*gorm.DB, 0, n)
+	for i := 0; i < n; i++ {
+		shards = append(shards, openTestDB(t))
+	}
+	return NewShardedUserDAO(index, shards, newTestIdGenerator(t)).(*ShardedUserDAO), index, shards
+}
+
+func TestShardedUserDao_Contacts(t *testing.T) {
+	d, _, _ := newTestShardedUserDAO(t, 3)
+	ctx := context.Background()
+	// 插够多的用户，保证每个库里都有
+	for i := 0; i < 20; i++ {
+		_, err := d.Insert(ctx, User{
+			Email: nullString(fmt.Sprintf("%d@qq.com", i)),
+			Phone: nullString(fmt.Sprintf("+861380000%04d", i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		u, err := d.FindByEmail(ctx, fmt.Sprintf("%d@qq.com", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Phone.String != fmt.Sprintf("+861380000%04d", i) {
+			t.Fatalf("按邮箱查到的用户不对 %+v", u)
+		}
+	}
+
+	// 邮箱和手机号全局唯一，不管新用户分到哪个库
+	if _, err := d.Insert(ctx, User{Email: nullString("0@qq.com")}); !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("期望 ErrDuplicateUser，实际 %v", err)
+	}
+	if _, err := d.Insert(ctx, User{Phone: nullString("+8613800000001")}); !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("期望 ErrDuplicateUser，实际 %v", err)
+	}
+
+	// 换绑之后旧手机号可以给别人用
+	u, err := d.FindByPhone(ctx, "+8613800000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.UpdatePhone(ctx, u.Id, "+8613900000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.UpdatePhone(ctx, u.Id, "+8613800000002"); !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("期望 ErrDuplicateUser，实际 %v", err)
+	}
+	if got, err := d.FindByPhone(ctx, "+8613900000000"); err != nil || got.Id != u.Id {
+		t.Fatalf("期望按新手机号查到用户 %d，实际 %v %v", u.Id, got.Id, err)
+	}
+	if _, err = d.Insert(ctx, User{Phone: nullString("+8613800000000")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 注销之后邮箱可以重新注册
+	u, err = d.FindByEmail(ctx, "1@qq.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Delete(ctx, u.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.FindByEmail(ctx, "1@qq.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("期望 ErrRecordNotFound，实际 %v", err)
+	}
+	if _, err = d.Insert(ctx, User{Email: nullString("1@qq.com")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedUserDao_StaleLookup(t *testing.T) {
+	testCases := []struct {
+		name    string
+		age     time.Duration
+		wantErr error
+	}{
+		{name: "刚写进去的索引可能是别人正在注册", age: 0, wantErr: ErrDuplicateUser},
+		{name: "很久之前留下的脏索引可以回收", age: time.Hour},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, index, _ := newTestShardedUserDAO(t, 2)
+			ctx := context.Background()
+			// 模拟占了索引之后进程挂了，用户没有写进去
+			err := index.Exec("INSERT INTO user_emails (email, uid, ctime) VALUES (?, ?, ?)",
+				"a@qq.com", 42, time.Now().Add(-tc.age).UnixMilli()).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := d.Insert(ctx, User{Email: nullString("a@qq.com")})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("期望 %v，实际 %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if u, err := d.FindByEmail(ctx, "a@qq.com"); err != nil || u.Id != id {
+				t.Fatalf("期望按邮箱查到用户 %d，实际 %v %v", id, u.Id, err)
+			}
+		})
+	}
+}
+
+func TestShardedUserDao_MergeAcrossShards(t *testing.T) {
+	d, _, _ := newTestShardedUserDAO(t, 2)
+	ctx := context.Background()
+	target, err := d.Insert(ctx, User{Email: nullString("a@qq.com"), Nickname: "target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 找一个和 target 不在同一个库的 source
+	var source int64
+	for i := 0; source == 0 || d.shard(source) == d.shard(target); i++ {
+		if source != 0 {
+			if err = d.Delete(ctx, source); err != nil {
+				t.Fatal(err)
+			}
+		}
+		source, err = d.Insert(ctx, User{Phone: nullString(fmt.Sprintf("+861380000%04d", i)), AboutMe: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := d.FindById(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Merge(ctx, target, source); err != nil {
+		t.Fatal(err)
+	}
+	u, err := d.FindByPhone(ctx, src.Phone.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Id != target || u.Email.String != "a@qq.com" || u.AboutMe != "hello" {
+		t.Fatalf("合并结果不对 %+v", u)
+	}
+	if _, err = d.FindById(ctx, source); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("合并之后 source 应该被删除，实际 %v", err)
+	}
+}
+
+func TestShardedUserDao_MergeDeleteFailed(t *testing.T) {
+	d, _, shards := newTestShardedUserDAO(t, 2)
+	ctx := context.Background()
+	target, err := d.Insert(ctx, User{Email: nullString("a@qq.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source int64
+	for i := 0; source == 0 || d.shard(source) == d.shard(target); i++ {
+		source, err = d.Insert(ctx, User{Phone: nullString(fmt.Sprintf("+861380000%04d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := d.FindById(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 target 已经保存成功，删除 source 的时候失败了
+	sdb := shards[shardOf(source, len(shards))]
+	const failDelete = "test:fail_delete"
+	err = sdb.Callback().Delete().Before("gorm:delete").Register(failDelete, func(db *gorm.DB) {
+		_ = db.AddError(errors.New("模拟删除失败"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Merge(ctx, target, source); err == nil {
+		t.Fatal("期望合并失败")
+	}
+	// 索引要留在 target 上，target 已经有这个手机号了
+	u, err := d.FindByPhone(ctx, src.Phone.String)
+	if err != nil || u.Id != target {
+		t.Fatalf("期望按手机号查到 target %d，实际 %v %v", target, u.Id, err)
+	}
+
+	// 重新合并一次就能删掉 source
+	if err = sdb.Callback().Delete().Remove(failDelete); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Merge(ctx, target, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.FindById(ctx, source); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("合并之后 source 应该被删除，实际 %v", err)
+	}
+	if u, err = d.FindByPhone(ctx, src.Phone.String); err != nil || u.Id != target {
+		t.Fatalf("期望按手机号查到 target %d，实际 %v %v", target, u.Id, err)
+	}
+}
+
+// TestShardedUserDao_MergeTargetChanged 读出 target 之后 target 改了密码，合并不能把改动覆盖掉
+func TestShardedUserDao_MergeTargetChanged(t *testing.T) {
+	d, _, shards := newTestShardedUserDAO(t, 2)
+	ctx := context.Background()
+	target, err := d.Insert(ctx, User{Email: nullString("a@qq.com"), Password: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source int64
+	for i := 0; source == 0 || d.shard(source) == d.shard(target); i++ {
+		source, err = d.Insert(ctx, User{Phone: nullString(fmt.Sprintf("+861380000%04d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := d.FindById(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 在写回 target 之前改掉密码，模拟合并的同时用户在别的设备上改了密码
+	tdb := shards[shardOf(target, len(shards))]
+	const changePassword = "test:change_password"
+	err = tdb.Callback().Update().Before("gorm:update").Register(changePassword, func(db *gorm.DB) {
+		_, err := db.Statement.ConnPool.ExecContext(db.Statement.Context,
+			"UPDATE users SET password = 'new', token_version = token_version + 1 WHERE id = ?", target)
+		if err != nil {
+			_ = db.AddError(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Merge(ctx, target, source); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("期望 ErrMergeConflict，实际 %v", err)
+	}
+	if err = tdb.Callback().Update().Remove(changePassword); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := d.FindById(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Password != "new" || u.TokenVersion != 1 || u.Phone.Valid {
+		t.Fatalf("target 不应该被合并覆盖 %+v", u)
+	}
+	// 索引要还原回 source
+	if u, err = d.FindByPhone(ctx, src.Phone.String); err != nil || u.Id != source {
+		t.Fatalf("期望按手机号查到 source %d，实际 %v %v", source, u.Id, err)
+	}
+}
+
+func TestShardedUserDao_ForcePrimary(t *testing.T) {
+	d, index, _ := newTestShardedUserDAO(t, 2)
+	// 从库是一个空库，模拟主从延迟
+	err := index.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{openTestDB(t).Dialector},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	id, err := d.Insert(ctx, User{Email: nullString("a@qq.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = d.FindByEmail(ctx, "a@qq.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("默认查从库，期望 ErrRecordNotFound，实际 %v", err)
+	}
+	u, err := d.FindByEmail(ForcePrimary(ctx), "a@qq.com")
+	if err != nil || u.Id != id {
+		t.Fatalf("强制走主库期望查到用户 %d，实际 %v %v", id, u.Id, err)
+	}
+}
+
+func TestShardedUserDao_Search(t *testing.T) {
+	d, _, _ := newTestShardedUserDAO(t, 3)
+	ctx := context.Background()
+	var want []int64
+	for i := 0; i < 10; i++ {
+		id, err := d.Insert(ctx, User{Nickname: fmt.Sprintf("user%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+	}
+
+	// 从新到旧翻页，结果要和不分库的时候一样
+	var got []int64
+	q := UserSearch{Desc: true, Limit: 3}
+	for {
+		users, err := d.Search(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range users {
+			got = append(got, u.Id)
+		}
+		if len(users) < q.Limit {
+			break
+		}
+		q.After = &UserSearchCursor{Id: users[len(users)-1].Id}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("期望 %d 个用户，实际 %d 个", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[len(want)-1-i] {
+			t.Fatalf("期望 %v 的倒序，实际 %v", want, got)
+		}
+	}
+}
+
+func TestReshardUsers(t *testing.T) {
+	// 一开始没有分库，所有用户都在一个库里
+	single, index, _ := newTestShardedUserDAO(t, 1)
+	ctx := context.Background()
+	var ids []int64
+	for i := 0; i < 30; i++ {
+		id, err := single.Insert(ctx, User{Email: nullString(fmt.Sprintf("%d@qq.com", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	from := []*gorm.DB{single.shards[0].db}
+	to := []*gorm.DB{single.shards[0].db, openTestDB(t), openTestDB(t)}
+	moved, err := ReshardUsers(ctx, index, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved == 0 || moved == len(ids) {
+		t.Fatalf("应该有一部分用户搬到新库，实际搬了 %d 个", moved)
+	}
+	// 重复执行不会出错
+	if _, err = ReshardUsers(ctx, index, from, to); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := CleanupShards(ctx, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != int64(moved) {
+		t.Fatalf("期望从旧库删除 %d 个，实际 %d 个", moved, deleted)
+	}
+
+	d := NewShardedUserDAO(index, to, newTestIdGenerator(t))
+	for i, id := range ids {
+		u, err := d.FindByEmail(ctx, fmt.Sprintf("%d@qq.com", i))
+		if err != nil || u.Id != id {
+			t.Fatalf("期望按邮箱查到用户 %d，实际 %v %v", id, u.Id, err)
+		}
+	}
+}
@@ -4,88 +4,513 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+	"strings"
 	"time"
 )
 
 var (
-	ErrDuplicateUser  = errors.New("用户冲突")
-	ErrRecordNotFound = gorm.ErrRecordNotFound
+	ErrDuplicateUser       = errors.New("用户冲突")
+	ErrRecordNotFound      = gorm.ErrRecordNotFound
+	ErrContactAlreadyBound = errors.New("已经绑定过手机号或者邮箱")
+	ErrMergeConflict       = errors.New("两个账号的信息冲突，无法合并")
+	ErrVersionConflict     = errors.New("资料已经被修改过了")
 )
 
+// AnyVersion 修改资料的时候不检查版本号，最后写入的生效，给还没有升级、不会带版本号的客户端用
+const AnyVersion int64 = -1
+
 type UserDAO interface {
-	Insert(ctx context.Context, u User) error
+	// Insert 返回新用户的 id
+	Insert(ctx context.Context, u User) (int64, error)
 	FindByEmail(ctx context.Context, email string) (User, error)
 	UpdateByUserId(ctx context.Context, entity User) error
 	FindById(ctx context.Context, uid int64) (User, error)
 	FindByPhone(ctx context.Context, phone string) (User, error)
+	BindPhone(ctx context.Context, uid int64, phone string) error
+	BindEmail(ctx context.Context, uid int64, email string) error
+	Merge(ctx context.Context, targetId int64, sourceId int64) error
+	UpdatePassword(ctx context.Context, uid int64, password string) error
+	RehashPassword(ctx context.Context, uid int64, oldHash string, newHash string) error
+	UpdatePhone(ctx context.Context, uid int64, phone string) error
+	UpdateEmail(ctx context.Context, uid int64, email string) error
+	UpdateAvatar(ctx context.Context, uid int64, avatar string) error
+	UpdatePrivacy(ctx context.Context, entity User) error
+	Search(ctx context.Context, q UserSearch) ([]User, error)
+	Delete(ctx context.Context, uid int64) error
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]User, error)
+}
+
+// IdGenerator 生成用户 id，不用数据库自增，避免暴露注册量，也方便以后分表
+type IdGenerator interface {
+	Next() (int64, error)
 }
 
 type GORMUserDao struct {
-	db *gorm.DB
+	db  *gorm.DB
+	ids IdGenerator
 }
 
-func NewUserDao(db *gorm.DB) UserDAO {
+type forcePrimaryKey struct{}
+
+// ForcePrimary 返回的 ctx 里的所有查询都走主库
+// 刚写完马上就要读的时候用，避免主从延迟导致读不到
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+func NewUserDao(db *gorm.DB, ids IdGenerator) UserDAO {
 	return &GORMUserDao{
-		db: db,
+		db:  db,
+		ids: ids,
 	}
 }
 
-func (dao *GORMUserDao) Insert(ctx context.Context, u User) error {
+// dbWithCtx 读操作默认走从库，ctx 里要求了走主库的话就强制走主库
+func (dao *GORMUserDao) dbWithCtx(ctx context.Context) *gorm.DB {
+	return withCtx(dao.db, ctx)
+}
+
+func withCtx(db *gorm.DB, ctx context.Context) *gorm.DB {
+	db = db.WithContext(ctx)
+	if forced, _ := ctx.Value(forcePrimaryKey{}).(bool); forced {
+		db = db.Clauses(dbresolver.Write)
+	}
+	return db
+}
+
+func (dao *GORMUserDao) Insert(ctx context.Context, u User) (int64, error) {
+	id, err := dao.ids.Next()
+	if err != nil {
+		return 0, err
+	}
+	u.Id = id
+	return id, dao.insert(ctx, u)
+}
+
+// insert 写入已经分配好 id 的用户
+func (dao *GORMUserDao) insert(ctx context.Context, u User) error {
 	now := time.Now().UnixMilli()
 	u.Ctime = now
 	u.Utime = now
-	err := dao.db.WithContext(ctx).Create(&u).Error
-
-	var me *mysql.MySQLError
-	if errors.As(err, &me) {
-		const duplicateErr uint16 = 1062 // 数据库 email 冲突
-		if me.Number == duplicateErr {
-			return ErrDuplicateUser
-		}
-	}
-	return err
+	return translateDuplicateErr(dao.db, dao.dbWithCtx(ctx).Create(&u).Error)
 }
 
 func (dao *GORMUserDao) FindByEmail(ctx context.Context, email string) (User, error) {
 	var u User
-	err := dao.db.WithContext(ctx).Where("email=?", email).First(&u).Error
+	err := dao.dbWithCtx(ctx).Where("email=?", email).First(&u).Error
 	return u, err
 }
 
+// UpdateByUserId 修改资料，entity.Version 是读出来时候的版本号，修改成功之后版本号加一
+// 中间被别的设备改过了，版本号对不上，返回 ErrVersionConflict；entity.Version 是 AnyVersion 的时候不检查
 func (dao *GORMUserDao) UpdateByUserId(ctx context.Context, entity User) error {
-	// 使用 dao.db.WithContext(ctx) 的目的是为了实现上下文控制。这个机制允许你在处理数据库请求时，如果上下文 ctx 被取消（例如由于超时或其它原因），则可以取消正在进行的数据库操作。
-	return dao.db.WithContext(ctx).Model(&entity).Where("id = ?", entity.Id).
-		// 就算是有的字段没有也可以更新吗？因为 User 结构体里有 Password 和 Email 之类的字段，但是这里没有传入，只需要传入本次需要修改的？
-		Updates(map[string]any{
-			"utime":    time.Now().UnixMilli(),
-			"nickname": entity.Nickname,
-			"birthday": entity.Birthday,
-			"about_me": entity.AboutMe,
-		}).Error
+	// 使用 dao.dbWithCtx(ctx) 的目的是为了实现上下文控制。这个机制允许你在处理数据库请求时，如果上下文 ctx 被取消（例如由于超时或其它原因），则可以取消正在进行的数据库操作。
+	db := dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", entity.Id)
+	if entity.Version != AnyVersion {
+		db = db.Where("version = ?", entity.Version)
+	}
+	// 就算是有的字段没有也可以更新吗？因为 User 结构体里有 Password 和 Email 之类的字段，但是这里没有传入，只需要传入本次需要修改的？
+	res := db.Updates(map[string]any{
+		"utime":    time.Now().UnixMilli(),
+		"nickname": entity.Nickname,
+		"birthday": entity.Birthday,
+		"about_me": entity.AboutMe,
+		"version":  gorm.Expr("version + 1"),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// 区分一下是用户不存在还是版本号对不上
+		if _, err := dao.FindById(ForcePrimary(ctx), entity.Id); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+	return nil
 }
 
 func (dao *GORMUserDao) FindById(ctx context.Context, uid int64) (User, error) {
 	var res User
-	err := dao.db.WithContext(ctx).Where("id = ?", uid).First(&res).Error
+	err := dao.dbWithCtx(ctx).Where("id = ?", uid).First(&res).Error
 	return res, err
 }
 
 func (dao *GORMUserDao) FindByPhone(ctx context.Context, phone string) (User, error) {
 	var res User
-	err := dao.db.WithContext(ctx).Where("phone = ?", phone).First(&res).Error
+	err := dao.dbWithCtx(ctx).Where("phone = ?", phone).First(&res).Error
+	return res, err
+}
+
+// UpdatePassword 更新密码，同时把 token_version 加一，让之前签发的 token 全部失效
+func (dao *GORMUserDao) UpdatePassword(ctx context.Context, uid int64, password string) error {
+	res := dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			"password":      password,
+			"token_version": gorm.Expr("token_version + 1"),
+			"utime":         time.Now().UnixMilli(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RehashPassword 密码没变，只是换了一种哈希算法，所以不动 token_version
+// 只有数据库里还是 oldHash 的时候才更新，避免覆盖掉并发修改的新密码
+func (dao *GORMUserDao) RehashPassword(ctx context.Context, uid int64, oldHash string, newHash string) error {
+	return dao.dbWithCtx(ctx).Model(&User{}).
+		Where("id = ? AND password = ?", uid, oldHash).
+		Updates(map[string]any{
+			"password": newHash,
+			"utime":    time.Now().UnixMilli(),
+		}).Error
+}
+
+// UpdatePhone 换绑手机号，新手机号被别人占用的时候返回 ErrDuplicateUser
+func (dao *GORMUserDao) UpdatePhone(ctx context.Context, uid int64, phone string) error {
+	return dao.updateContact(ctx, uid, "phone", phone)
+}
+
+// UpdateEmail 换绑邮箱，新邮箱被别人占用的时候返回 ErrDuplicateUser
+func (dao *GORMUserDao) UpdateEmail(ctx context.Context, uid int64, email string) error {
+	return dao.updateContact(ctx, uid, "email", email)
+}
+
+// UpdateAvatar 更新头像
+func (dao *GORMUserDao) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	return dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			"avatar": avatar,
+			"utime":  time.Now().UnixMilli(),
+		}).Error
+}
+
+// UpdatePrivacy 更新隐私设置
+func (dao *GORMUserDao) UpdatePrivacy(ctx context.Context, entity User) error {
+	return dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", entity.Id).
+		Updates(map[string]any{
+			"hide_birthday":    entity.HideBirthday,
+			"hide_about_me":    entity.HideAboutMe,
+			"hide_from_search": entity.HideFromSearch,
+			"utime":            time.Now().UnixMilli(),
+		}).Error
+}
+
+func (dao *GORMUserDao) updateContact(ctx context.Context, uid int64, column string, val string) error {
+	res := dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			column:  val,
+			"utime": time.Now().UnixMilli(),
+		})
+	if res.Error != nil {
+		return translateDuplicateErr(dao.db, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete 软删除用户，同时清空手机号和邮箱，让别人可以重新用它们注册
+// 软删除之后所有的查询都查不到这个用户了，等过了保留期再由 PurgeDeleted 真正删除
+func (dao *GORMUserDao) Delete(ctx context.Context, uid int64) error {
+	res := dao.dbWithCtx(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			"email":      nil,
+			"phone":      nil,
+			"deleted_at": time.Now(),
+			"utime":      time.Now().UnixMilli(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeleted 真正删除在 before 之前软删除的用户，一次最多删除 limit 条
+// 返回删除的用户，只查了 Id 和 Avatar，调用方要用它们清理头像这类不在数据库里的数据
+// 先查出 id 再删，SQLite 不支持 DELETE ... LIMIT，MySQL 又不支持 IN 子查询里面带 LIMIT
+func (dao *GORMUserDao) PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]User, error) {
+	var users []User
+	err := dao.dbWithCtx(ctx).Clauses(dbresolver.Write).Unscoped().Model(&User{}).
+		Select("id", "avatar").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("id").Limit(limit).Find(&users).Error
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.Id)
+	}
+	err = dao.dbWithCtx(ctx).Unscoped().Where("id IN ?", ids).Delete(&User{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// BindPhone 给还没有手机号的用户绑定手机号
+func (dao *GORMUserDao) BindPhone(ctx context.Context, uid int64, phone string) error {
+	return dao.bind(ctx, uid, "phone", phone)
+}
+
+// BindEmail 给还没有邮箱的用户绑定邮箱
+func (dao *GORMUserDao) BindEmail(ctx context.Context, uid int64, email string) error {
+	return dao.bind(ctx, uid, "email", email)
+}
+
+func (dao *GORMUserDao) bind(ctx context.Context, uid int64, column string, val string) error {
+	// 只有原本为 NULL 的时候才能绑定，换绑是另外的流程
+	res := dao.dbWithCtx(ctx).Model(&User{}).
+		Where("id = ?", uid).Where(column + " IS NULL").
+		Updates(map[string]any{
+			column:  val,
+			"utime": time.Now().UnixMilli(),
+		})
+	if res.Error != nil {
+		// 唯一索引冲突，说明已经被别的账号绑定了
+		return translateDuplicateErr(dao.db, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrContactAlreadyBound
+	}
+	return nil
+}
+
+// Merge 把 source 账号合并到 target 账号上，合并完之后 source 账号会被删除
+// 手机号和邮箱只能有一个，两边都有并且不一样的时候返回 ErrMergeConflict
+// 其余的资料以 target 为准，target 上没有的才用 source 的补上
+func (dao *GORMUserDao) Merge(ctx context.Context, targetId int64, sourceId int64) error {
+	if targetId == sourceId {
+		return ErrMergeConflict
+	}
+	return dao.dbWithCtx(ctx).Transaction(func(tx *gorm.DB) error {
+		// 按照 id 的顺序加锁，避免两个方向同时合并的时候死锁
+		var users []User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []int64{targetId, sourceId}).
+			Order("id").Find(&users).Error
+		if err != nil {
+			return err
+		}
+		if len(users) != 2 {
+			return ErrRecordNotFound
+		}
+		target, source := users[0], users[1]
+		if target.Id != targetId {
+			target, source = source, target
+		}
+
+		merged, err := mergeUser(target, source)
+		if err != nil {
+			return err
+		}
+
+		// 先删掉 source，把唯一索引上的手机号和邮箱空出来
+		// 合并是真的删除，不走软删除
+		err = tx.Unscoped().Delete(&User{}, source.Id).Error
+		if err != nil {
+			return err
+		}
+		merged.Utime = time.Now().UnixMilli()
+		return translateDuplicateErr(tx, tx.Save(&merged).Error)
+	})
+}
+
+// mergeInto 跨库合并账号的时候把合并后的资料写回 target，只改合并涉及的列
+// 读 target 的时候没有加锁，用 version 和 token_version 确认这期间 target 没有改过资料、密码，改过就返回 ErrMergeConflict
+func (dao *GORMUserDao) mergeInto(ctx context.Context, target User, merged User) error {
+	res := dao.dbWithCtx(ctx).Model(&User{}).
+		Where("id = ? AND version = ? AND token_version = ?", target.Id, target.Version, target.TokenVersion).
+		Updates(map[string]any{
+			"email":    merged.Email,
+			"phone":    merged.Phone,
+			"password": merged.Password,
+			"nickname": merged.Nickname,
+			"birthday": merged.Birthday,
+			"about_me": merged.AboutMe,
+			"version":  merged.Version,
+			"utime":    time.Now().UnixMilli(),
+		})
+	if err := translateDuplicateErr(dao.db, res.Error); err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrMergeConflict
+	}
+	return nil
+}
+
+// hardDelete 直接删除，不走软删除
+func (dao *GORMUserDao) hardDelete(ctx context.Context, uid int64) error {
+	return dao.dbWithCtx(ctx).Unscoped().Delete(&User{}, uid).Error
+}
+
+// mergeUser 合并两个账号的资料，冲突的时候返回 ErrMergeConflict
+func mergeUser(target User, source User) (User, error) {
+	var err error
+	target.Email, err = mergeNullString(target.Email, source.Email)
+	if err != nil {
+		return User{}, err
+	}
+	target.Phone, err = mergeNullString(target.Phone, source.Phone)
+	if err != nil {
+		return User{}, err
+	}
+	if target.Password == "" {
+		target.Password = source.Password
+	}
+	if target.Nickname == "" {
+		target.Nickname = source.Nickname
+	}
+	if target.Birthday == 0 {
+		target.Birthday = source.Birthday
+	}
+	if target.AboutMe == "" {
+		target.AboutMe = source.AboutMe
+	}
+	// 资料可能被 source 补上了，让其他设备上打开的编辑页面失效
+	target.Version++
+	return target, nil
+}
+
+func mergeNullString(target sql.NullString, source sql.NullString) (sql.NullString, error) {
+	switch {
+	case !source.Valid:
+		return target, nil
+	case !target.Valid:
+		return source, nil
+	case target.String == source.String:
+		return target, nil
+	default:
+		return sql.NullString{}, ErrMergeConflict
+	}
+}
+
+// UserSearch 管理后台查询用户的条件
+type UserSearch struct {
+	// CtimeStart 和 CtimeEnd 为 0 表示不限制
+	CtimeStart     int64
+	CtimeEnd       int64
+	HasPhone       *bool
+	HasEmail       *bool
+	NicknamePrefix string
+
+	SortByCtime bool
+	Desc        bool
+	// After 为 nil 表示从第一页开始
+	After *UserSearchCursor
+	Limit int
+}
+
+type UserSearchCursor struct {
+	Ctime int64
+	Id    int64
+}
+
+// Search 按照条件查询用户，使用 keyset 分页，翻页的代价不会随着页数增加而增加
+func (dao *GORMUserDao) Search(ctx context.Context, q UserSearch) ([]User, error) {
+	db := dao.dbWithCtx(ctx).Model(&User{})
+	if q.CtimeStart > 0 {
+		db = db.Where("ctime >= ?", q.CtimeStart)
+	}
+	if q.CtimeEnd > 0 {
+		db = db.Where("ctime < ?", q.CtimeEnd)
+	}
+	if q.HasPhone != nil {
+		db = db.Where(nullCondition("phone", *q.HasPhone))
+	}
+	if q.HasEmail != nil {
+		db = db.Where(nullCondition("email", *q.HasEmail))
+	}
+	if q.NicknamePrefix != "" {
+		db = db.Where("nickname LIKE ? ESCAPE '!'", escapeLike(q.NicknamePrefix)+"%")
+	}
+
+	op, order := ">", "ASC"
+	if q.Desc {
+		op, order = "<", "DESC"
+	}
+	if q.SortByCtime {
+		if q.After != nil {
+			db = db.Where("ctime "+op+" ? OR (ctime = ? AND id "+op+" ?)",
+				q.After.Ctime, q.After.Ctime, q.After.Id)
+		}
+		db = db.Order("ctime " + order).Order("id " + order)
+	} else {
+		if q.After != nil {
+			db = db.Where("id "+op+" ?", q.After.Id)
+		}
+		db = db.Order("id " + order)
+	}
+
+	var res []User
+	err := db.Limit(q.Limit).Find(&res).Error
 	return res, err
 }
 
+func nullCondition(column string, notNull bool) string {
+	if notNull {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
+
+// escapeLike 转义 LIKE 里面的通配符
+// 用 ! 而不是反斜杠做转义字符，SQLite 没有默认的转义字符，反斜杠在 MySQL 的字符串里又要再转义一次
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// translateDuplicateErr 把唯一索引冲突的错误转成 ErrDuplicateUser，其余错误原样返回
+// 不同数据库的错误码不一样（MySQL 是 1062，SQLite 是 2067），交给 GORM 各个驱动自己的 ErrorTranslator 去识别
+func translateDuplicateErr(db *gorm.DB, err error) error {
+	if err == nil {
+		return nil
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicateUser
+	}
+	return err
+}
+
 type User struct {
-	Email    sql.NullString `gorm:"unique"`
+	Email sql.NullString `gorm:"unique"`
+	// Phone 统一存储 E.164 格式，例如 +8613800000000
 	Phone    sql.NullString `gorm:"unique"`
 	Password string
-	Ctime    int64
-	Utime    int64
+	// Ctime 管理后台会按照注册时间过滤和排序
+	Ctime int64 `gorm:"index"`
+	Utime int64
+	// Id 由 IdGenerator 生成，表上的自增只是为了兼容老数据
 	Id       int64  `gorm:"PrimaryKey,autoIncrement"`
 	Nickname string `gorm:"type=varchar(128)"`
 	Birthday int64
 	AboutMe  string `gorm:"type=varchar(4096)"`
+	Avatar   string `gorm:"type:varchar(1024)"`
+	// 隐私设置
+	HideBirthday   bool  `gorm:"not null;default:false"`
+	HideAboutMe    bool  `gorm:"not null;default:false"`
+	HideFromSearch bool  `gorm:"not null;default:false"`
+	Role           uint8 `gorm:"not null;default:0"`
+	// TokenVersion 修改密码的时候加一，用来让旧的 token 失效
+	TokenVersion int64 `gorm:"not null;default:0"`
+	// Version 资料的版本号，每次修改昵称、生日、简介都加一，用来做乐观锁
+	Version int64 `gorm:"not null;default:0"`
+	// DeletedAt 软删除的时间，GORM 会自动在所有查询上加上 deleted_at IS NULL 的条件
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
@@ -0,0 +1,28 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// WalkUserIds 按 id 顺序分批遍历所有用户 id（包括已经注销、还没彻底删除的），用来预热布隆过滤器之类的
+func WalkUserIds(ctx context.Context, db *gorm.DB, fn func(ids []int64) error) error {
+	const batchSize = 1000
+	var lastId int64
+	for {
+		var ids []int64
+		err := db.WithContext(ctx).Unscoped().Model(&User{}).
+			Where("id > ?", lastId).
+			Order("id").Limit(batchSize).Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err = fn(ids); err != nil {
+			return err
+		}
+		lastId = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ReshardUsers 把 from 里的用户按照 to 的分库重新分布，同时重建 index 库里的邮箱、手机号索引，返回搬过去的用户数
+// 第一次分库的时候 from 就是原来的单库。from 和 to 里是同一个 *gorm.DB 的不用搬，可以重复执行。
+//
+// 完整的流程：停止写入 → ReshardUsers → 把配置改成新的分库并发布 → CleanupShards 删掉不属于各个库的用户
+func ReshardUsers(ctx context.Context, index *gorm.DB, from []*gorm.DB, to []*gorm.DB) (int, error) {
+	const batchSize = 500
+	var moved int
+	for _, src := range from {
+		var lastId int64
+		for {
+			var users []User
+			err := src.WithContext(ctx).Unscoped().
+				Where("id > ?", lastId).
+				Order("id").Limit(batchSize).Find(&users).Error
+			if err != nil {
+				return moved, err
+			}
+			if len(users) == 0 {
+				break
+			}
+			lastId = users[len(users)-1].Id
+
+			for _, u := range users {
+				if dst := to[shardOf(u.Id, len(to))]; dst != src {
+					// 注销了还没彻底删除的用户也要搬过去，保留期过了再由 PurgeDeleted 删除
+					err = dst.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&u).Error
+					if err != nil {
+						return moved, err
+					}
+					moved++
+				}
+				for _, c := range contactsOf(u) {
+					if err = upsertLookup(ctx, index, c, u.Id); err != nil {
+						return moved, err
+					}
+				}
+			}
+		}
+	}
+	return moved, nil
+}
+
+// CleanupShards 删掉不属于各个库的用户，要在服务已经切换到新的分库之后执行
+func CleanupShards(ctx context.Context, shards []*gorm.DB) (int64, error) {
+	var deleted int64
+	for i, db := range shards {
+		err := WalkUserIds(ctx, db, func(ids []int64) error {
+			var stale []int64
+			for _, id := range ids {
+				if shardOf(id, len(shards)) != i {
+					stale = append(stale, id)
+				}
+			}
+			if len(stale) == 0 {
+				return nil
+			}
+			res := db.WithContext(ctx).Unscoped().Where("id IN ?", stale).Delete(&User{})
+			deleted += res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func upsertLookup(ctx context.Context, index *gorm.DB, c contact, uid int64) error {
+	return index.WithContext(ctx).Table(c.table()).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: c.column}},
+			DoUpdates: clause.AssignmentColumns([]string{"uid", "ctime"}),
+		}).
+		Create(map[string]any{
+			c.column: c.val,
+			"uid":    uid,
+			"ctime":  time.Now().UnixMilli(),
+		}).Error
+}
@@ -0,0 +1,278 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"mini-ebook/pkg/snowflake"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB 每个测试一个 SQLite 文件，用迁移文件建表，和线上的表结构保持一致
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestIdGenerator(t *testing.T) IdGenerator {
+	t.Helper()
+	ids, err := snowflake.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func newTestUserDAO(t *testing.T) (UserDAO, *gorm.DB) {
+	db := openTestDB(t)
+	return NewUserDao(db, newTestIdGenerator(t)), db
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func TestGORMUserDao_Insert(t *testing.T) {
+	d, _ := newTestUserDAO(t)
+	ctx := context.Background()
+	id, err := d.Insert(ctx, User{Email: nullString("a@qq.com"), Phone: nullString("+8613800000000")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := d.FindByEmail(ctx, "a@qq.com")
+	if err != nil || u.Id != id {
+		t.Fatalf("期望按邮箱查到用户 %d，实际 %v %v", id, u.Id, err)
+	}
+	u, err = d.FindByPhone(ForcePrimary(ctx), "+8613800000000")
+	if err != nil || u.Id != id {
+		t.Fatalf("期望按手机号查到用户 %d，实际 %v %v", id, u.Id, err)
+	}
+
+	_, err = d.Insert(ctx, User{Email: nullString("a@qq.com")})
+	if !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("邮箱重复期望 ErrDuplicateUser，实际 %v", err)
+	}
+	_, err = d.Insert(ctx, User{Phone: nullString("+8613800000000")})
+	if !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("手机号重复期望 ErrDuplicateUser，实际 %v", err)
+	}
+}
+
+func TestGORMUserDao_UpdateByUserId(t *testing.T) {
+	testCases := []struct {
+		name    string
+		uid     func(id int64) int64
+		version int64
+		wantErr error
+	}{
+		{name: "版本号一致", uid: func(id int64) int64 { return id }, version: 0},
+		{name: "被别的设备改过了", uid: func(id int64) int64 { return id }, version: 1, wantErr: ErrVersionConflict},
+		{name: "客户端没有带版本号", uid: func(id int64) int64 { return id }, version: AnyVersion},
+		{name: "用户不存在", uid: func(id int64) int64 { return id + 1 }, wantErr: ErrRecordNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, _ := newTestUserDAO(t)
+			ctx := context.Background()
+			id, err := d.Insert(ctx, User{Nickname: "old"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = d.UpdateByUserId(ctx, User{Id: tc.uid(id), Nickname: "new", Version: tc.version})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("期望 %v，实际 %v", tc.wantErr, err)
+			}
+			u, err := d.FindById(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantNickname, wantVersion := "new", int64(1)
+			if tc.wantErr != nil {
+				wantNickname, wantVersion = "old", 0
+			}
+			if u.Nickname != wantNickname || u.Version != wantVersion {
+				t.Fatalf("期望昵称 %s 版本 %d，实际 %s %d", wantNickname, wantVersion, u.Nickname, u.Version)
+			}
+		})
+	}
+}
+
+func TestGORMUserDao_Bind(t *testing.T) {
+	testCases := []struct {
+		name    string
+		bind    func(ctx context.Context, d UserDAO, uid int64) error
+		wantErr error
+	}{
+		{
+			name: "绑定手机号",
+			bind: func(ctx context.Context, d UserDAO, uid int64) error {
+				return d.BindPhone(ctx, uid, "+8613900000000")
+			},
+		},
+		{
+			name: "已经有邮箱了",
+			bind: func(ctx context.Context, d UserDAO, uid int64) error {
+				return d.BindEmail(ctx, uid, "c@qq.com")
+			},
+			wantErr: ErrContactAlreadyBound,
+		},
+		{
+			name: "手机号被别人绑定了",
+			bind: func(ctx context.Context, d UserDAO, uid int64) error {
+				return d.BindPhone(ctx, uid, "+8613800000000")
+			},
+			wantErr: ErrDuplicateUser,
+		},
+		{
+			name: "换绑的邮箱被别人占用了",
+			bind: func(ctx context.Context, d UserDAO, uid int64) error {
+				return d.UpdateEmail(ctx, uid, "b@qq.com")
+			},
+			wantErr: ErrDuplicateUser,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, _ := newTestUserDAO(t)
+			ctx := context.Background()
+			uid, err := d.Insert(ctx, User{Email: nullString("a@qq.com")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = d.Insert(ctx, User{Email: nullString("b@qq.com"), Phone: nullString("+8613800000000")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tc.bind(ctx, d, uid); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("期望 %v，实际 %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGORMUserDao_Merge(t *testing.T) {
+	d, _ := newTestUserDAO(t)
+	ctx := context.Background()
+	target, err := d.Insert(ctx, User{Email: nullString("a@qq.com"), Nickname: "target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := d.Insert(ctx, User{Phone: nullString("+8613800000000"), Nickname: "source", AboutMe: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Merge(ctx, target, source); err != nil {
+		t.Fatal(err)
+	}
+	u, err := d.FindById(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Phone.String != "+8613800000000" || u.Nickname != "target" || u.AboutMe != "hello" {
+		t.Fatalf("合并结果不对 %+v", u)
+	}
+	if _, err = d.FindById(ctx, source); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("合并之后 source 应该被删除，实际 %v", err)
+	}
+}
+
+func TestGORMUserDao_DeleteAndPurge(t *testing.T) {
+	d, db := newTestUserDAO(t)
+	ctx := context.Background()
+	var ids []int64
+	for i, email := range []string{"a@qq.com", "b@qq.com", "c@qq.com"} {
+		id, err := d.Insert(ctx, User{Email: nullString(email), Avatar: fmt.Sprintf("/static/avatars/%d.jpg", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := d.FindById(ctx, ids[0]); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("软删除之后应该查不到，实际 %v", err)
+	}
+	// 软删除会清空邮箱，别人可以重新用它注册
+	if _, err := d.Insert(ctx, User{Email: nullString("a@qq.com")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 保留期内的不删
+	purged, err := d.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 10)
+	if err != nil || len(purged) != 0 {
+		t.Fatalf("期望不删除，实际删除了 %d 条 %v", len(purged), err)
+	}
+	purged, err = d.PurgeDeleted(ctx, time.Now().Add(time.Second), 2)
+	if err != nil || len(purged) != 2 {
+		t.Fatalf("期望删除 2 条，实际 %d 条 %v", len(purged), err)
+	}
+	// 调用方要用头像清理文件
+	if purged[0].Id != ids[0] || purged[0].Avatar != "/static/avatars/0.jpg" {
+		t.Fatalf("返回的用户不对 %+v", purged[0])
+	}
+	var left int64
+	if err = db.Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL").Count(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Fatalf("期望还剩 1 条软删除的数据，实际 %d", left)
+	}
+}
+
+func TestGORMUserDao_Search(t *testing.T) {
+	d, _ := newTestUserDAO(t)
+	ctx := context.Background()
+	for _, nickname := range []string{"a_1", "a_2", "ab", "a_3", "b_1"} {
+		if _, err := d.Insert(ctx, User{Nickname: nickname}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 下划线要按字面匹配，不能匹配到 ab
+	var got []string
+	q := UserSearch{NicknamePrefix: "a_", Limit: 2}
+	for {
+		users, err := d.Search(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range users {
+			got = append(got, u.Nickname)
+		}
+		if len(users) < q.Limit {
+			break
+		}
+		q.After = &UserSearchCursor{Id: users[len(users)-1].Id}
+	}
+	want := []string{"a_1", "a_2", "a_3"}
+	if len(got) != len(want) {
+		t.Fatalf("期望 %v，实际 %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("期望 %v，实际 %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"mini-ebook/internal/repository/cache"
+	"time"
+)
+
+var (
+	ErrLoginLocked      = cache.ErrLoginLocked
+	ErrLoginTooFrequent = cache.ErrLoginTooFrequent
+)
+
+type LoginAttemptLimit = cache.LoginAttemptLimit
+
+type LoginAttemptRepository interface {
+	Check(ctx context.Context, biz, id string, limit LoginAttemptLimit) (time.Duration, error)
+	Fail(ctx context.Context, biz, id string, limit LoginAttemptLimit) error
+	Reset(ctx context.Context, biz, id string) error
+}
+
+type CachedLoginAttemptRepository struct {
+	cache cache.LoginAttemptCache
+}
+
+func NewLoginAttemptRepository(c cache.LoginAttemptCache) LoginAttemptRepository {
+	return &CachedLoginAttemptRepository{
+		cache: c,
+	}
+}
+
+func (repo *CachedLoginAttemptRepository) Check(ctx context.Context, biz, id string, limit LoginAttemptLimit) (time.Duration, error) {
+	return repo.cache.Check(ctx, biz, id, limit)
+}
+
+func (repo *CachedLoginAttemptRepository) Fail(ctx context.Context, biz, id string, limit LoginAttemptLimit) error {
+	return repo.cache.Fail(ctx, biz, id, limit)
+}
+
+func (repo *CachedLoginAttemptRepository) Reset(ctx context.Context, biz, id string) error {
+	return repo.cache.Reset(ctx, biz, id)
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"mini-ebook/internal/repository/cache"
+)
+
+var (
+	ErrMagicLinkSendTooMany = cache.ErrMagicLinkSendTooMany
+	ErrMagicLinkNotFound    = cache.ErrMagicLinkNotFound
+)
+
+type MagicLinkRepository interface {
+	Store(ctx context.Context, tokenId, email string) error
+	Consume(ctx context.Context, tokenId string) (string, error)
+}
+
+type CachedMagicLinkRepository struct {
+	cache cache.MagicLinkCache
+}
+
+func NewMagicLinkRepository(c cache.MagicLinkCache) MagicLinkRepository {
+	return &CachedMagicLinkRepository{
+		cache: c,
+	}
+}
+
+func (repo *CachedMagicLinkRepository) Store(ctx context.Context, tokenId, email string) error {
+	return repo.cache.Set(ctx, tokenId, email)
+}
+
+func (repo *CachedMagicLinkRepository) Consume(ctx context.Context, tokenId string) (string, error) {
+	return repo.cache.Consume(ctx, tokenId)
+}
@@ -3,16 +3,41 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/singleflight"
 	"log"
 	"mini-ebook/internal/domain"
 	"mini-ebook/internal/repository/cache"
 	"mini-ebook/internal/repository/dao"
+	"strconv"
 	"time"
 )
 
 var (
-	ErrDuplicateUser = dao.ErrDuplicateUser
-	ErrUserNotFound  = dao.ErrRecordNotFound
+	ErrDuplicateUser       = dao.ErrDuplicateUser
+	ErrUserNotFound        = dao.ErrRecordNotFound
+	ErrContactAlreadyBound = dao.ErrContactAlreadyBound
+	ErrMergeConflict       = dao.ErrMergeConflict
+	ErrVersionConflict     = dao.ErrVersionConflict
+	// ErrDegraded Redis 不可用，查数据库的并发也满了，只能先拒绝
+	ErrDegraded = errors.New("缓存不可用，服务降级中")
+)
+
+// AnyUserVersion 修改资料的时候不检查版本号，见 dao.AnyVersion
+const AnyUserVersion = dao.AnyVersion
+
+// ForcePrimary 返回的 ctx 里的所有查询都走主库，见 dao.ForcePrimary
+func ForcePrimary(ctx context.Context) context.Context {
+	return dao.ForcePrimary(ctx)
+}
+
+const (
+	// rebuildCacheTimeout 重建缓存不跟着调用方的 ctx 取消，用这个超时兜底
+	rebuildCacheTimeout = 3 * time.Second
+	// redisRetries 删缓存、加布隆过滤器这类不能只打日志的 Redis 操作，最多尝试几次
+	redisRetries       = 3
+	redisRetryInterval = 50 * time.Millisecond
 )
 
 type UserRepository interface {
@@ -20,24 +45,81 @@ type UserRepository interface {
 	FindByEmail(ctx context.Context, email string) (domain.User, error)
 	UpdateUserInfo(ctx context.Context, u domain.User) error
 	UpdateNonZeroFields(ctx context.Context, user domain.User) error
+	// FindById 优先读缓存，返回的用户不带密码哈希
 	FindById(ctx context.Context, uid int64) (domain.User, error)
+	// FindByIdWithPassword 直接查数据库，带着密码哈希，只在需要校验密码的时候用
+	FindByIdWithPassword(ctx context.Context, uid int64) (domain.User, error)
 	FindByPhone(ctx context.Context, phone string) (domain.User, error)
+	BindPhone(ctx context.Context, uid int64, phone string) error
+	BindEmail(ctx context.Context, uid int64, email string) error
+	Merge(ctx context.Context, targetId int64, sourceId int64) error
+	UpdatePassword(ctx context.Context, uid int64, password string) error
+	RehashPassword(ctx context.Context, uid int64, oldHash string, newHash string) error
+	UpdatePhone(ctx context.Context, uid int64, phone string) error
+	UpdateEmail(ctx context.Context, uid int64, email string) error
+	UpdateAvatar(ctx context.Context, uid int64, avatar string) error
+	UpdatePrivacy(ctx context.Context, uid int64, privacy domain.PrivacySettings) error
+	Search(ctx context.Context, q domain.UserSearch) ([]domain.User, error)
+	Delete(ctx context.Context, uid int64) error
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]domain.User, error)
 }
 
 type CachedUserRepository struct {
 	dao   dao.UserDAO
 	cache cache.UserCache
+	opts  CachedUserRepositoryOptions
+	// rebuildGroup 缓存没命中的时候，合并同一个用户的并发请求
+	rebuildGroup singleflight.Group
+	// degradeSem Redis 不可用的时候，限制直接查数据库的并发数
+	degradeSem chan struct{}
+}
+
+// CachedUserRepositoryOptions 缓存相关的可选功能，零值表示都不开启
+type CachedUserRepositoryOptions struct {
+	// DoubleDeleteDelay 写完数据库删缓存之后，过这么久再删一次，
+	// 避免并发的读请求在两次操作之间把旧数据又写回缓存
+	DoubleDeleteDelay time.Duration
+	// Bloom 查数据库之前先问一下布隆过滤器，不存在的 id 直接返回
+	// 开启之前要先用 cmd/build_user_bloom 把已有的用户 id 加进去
+	Bloom cache.UserBloomFilter
+	// RebuildLock 缓存没命中的时候，多个实例之间也只让一个去查数据库
+	RebuildLock cache.UserRebuildLock
+	// DegradeConcurrency Redis 不可用的时候，最多同时有多少个请求直接查数据库，0 表示不限制
+	DegradeConcurrency int
 }
 
 func NewCachedUserRepository(dao dao.UserDAO, c cache.UserCache) UserRepository {
-	return &CachedUserRepository{
+	return NewCachedUserRepositoryWithOptions(dao, c, CachedUserRepositoryOptions{})
+}
+
+func NewCachedUserRepositoryWithOptions(dao dao.UserDAO, c cache.UserCache, opts CachedUserRepositoryOptions) UserRepository {
+	repo := &CachedUserRepository{
 		dao:   dao,
 		cache: c,
+		opts:  opts,
+	}
+	if opts.DegradeConcurrency > 0 {
+		repo.degradeSem = make(chan struct{}, opts.DegradeConcurrency)
 	}
+	return repo
 }
 
+// Create 新用户的 id 可能之前被当成不存在缓存过，所以也要删缓存
 func (repo *CachedUserRepository) Create(ctx context.Context, u domain.User) error {
-	return repo.dao.Insert(ctx, repo.toDaoEntity(u))
+	uid, err := repo.dao.Insert(ctx, repo.toDaoEntity(u))
+	if err != nil {
+		return err
+	}
+	if repo.opts.Bloom != nil {
+		// 布隆过滤器里没有的 id 查都不查直接返回不存在，加不进去这个用户就再也查不到了，不能只打日志
+		err = retry(ctx, fmt.Sprintf("用户 %d 加入布隆过滤器", uid), func() error {
+			return repo.opts.Bloom.Add(ctx, uid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return repo.invalidate(ctx, uid)
 }
 
 // FindByEmail 根据邮箱查询用户信息
@@ -51,26 +133,108 @@ func (repo *CachedUserRepository) FindByEmail(ctx context.Context, email string)
 
 // UpdateUserInfo 更新用户信息
 func (repo *CachedUserRepository) UpdateUserInfo(ctx context.Context, u domain.User) error {
-	return repo.dao.UpdateByUserId(ctx, repo.toDaoEntity(u))
+	err := repo.dao.UpdateByUserId(ctx, repo.toDaoEntity(u))
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, u.Id)
 }
 
 // FindById 根据 UserId 查询用户信息
 func (repo *CachedUserRepository) FindById(ctx context.Context, uid int64) (domain.User, error) {
 	du, err := repo.cache.Get(ctx, uid)
-	// 只要 err 为 nil 就返回，err 不为 nil 就去查询数据库
-	// 而 err 有两种可能：
-	// 1、key 不存在，说明 redis 是正常的。
-	// 2、访问 redis 有问题，可能是网络有问题，也有可能是 redis 本身就崩溃了。
-	if err == nil {
+	switch {
+	case err == nil:
 		return du, nil
+	case errors.Is(err, cache.ErrUserNotExist):
+		// 缓存了用户不存在
+		return domain.User{}, ErrUserNotFound
+	case !errors.Is(err, cache.ErrKeyNotExist):
+		// 访问 redis 有问题，可能是网络有问题，也有可能是 redis 本身就崩溃了
+		// 这时候所有请求都会打到数据库，要限流保护数据库
+		log.Println(err)
+		return repo.findByIdDegraded(ctx, uid)
+	}
+	if repo.opts.Bloom != nil {
+		// 布隆过滤器出错就当作可能存在，继续查数据库
+		ok, err := repo.opts.Bloom.MightContain(ctx, uid)
+		if err == nil && !ok {
+			return domain.User{}, ErrUserNotFound
+		}
 	}
 
+	// 同一个进程里，同一个用户只放一个请求去重建缓存，其它的等着共享结果
+	// 结果是大家共享的，不能因为发起重建的那个请求被取消了，其它请求也跟着失败，所以重建用自己的超时
+	ch := repo.rebuildGroup.DoChan(strconv.FormatInt(uid, 10), func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rebuildCacheTimeout)
+		defer cancel()
+		return repo.rebuildCache(ctx, uid)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return domain.User{}, res.Err
+		}
+		return res.Val.(domain.User), nil
+	case <-ctx.Done():
+		return domain.User{}, ctx.Err()
+	}
+}
+
+// findByIdDegraded Redis 不可用的时候直接查数据库，并发数超过上限就直接拒绝
+func (repo *CachedUserRepository) findByIdDegraded(ctx context.Context, uid int64) (domain.User, error) {
+	if repo.degradeSem != nil {
+		select {
+		case repo.degradeSem <- struct{}{}:
+			defer func() { <-repo.degradeSem }()
+		default:
+			return domain.User{}, ErrDegraded
+		}
+	}
 	u, err := repo.dao.FindById(ctx, uid)
 	if err != nil {
 		return domain.User{}, err
 	}
+	return repo.toDomainWithoutPassword(u), nil
+}
+
+// rebuildCache 从数据库加载用户，写回缓存
+// 写操作之后会删缓存，紧接着的重建如果读到了还没同步的从库，旧数据就又进缓存了，所以重建缓存强制走主库
+func (repo *CachedUserRepository) rebuildCache(ctx context.Context, uid int64) (domain.User, error) {
+	ctx = ForcePrimary(ctx)
+	if repo.opts.RebuildLock != nil {
+		token, ok, err := repo.opts.RebuildLock.TryLock(ctx, uid)
+		switch {
+		case err != nil:
+			// 锁出问题了就不管锁，直接查数据库
+			log.Println(err)
+		case ok:
+			defer func() {
+				if err := repo.opts.RebuildLock.Unlock(ctx, uid, token); err != nil {
+					log.Println(err)
+				}
+			}()
+		default:
+			// 别的实例正在重建，等一下再读缓存，等不到再自己查数据库
+			du, err := repo.waitForRebuild(ctx, uid)
+			if err == nil || errors.Is(err, ErrUserNotFound) {
+				return du, err
+			}
+		}
+	}
 
-	du = repo.toDomain(u)
+	u, err := repo.dao.FindById(ctx, uid)
+	if errors.Is(err, ErrUserNotFound) {
+		if err := repo.cache.SetNotFound(ctx, uid); err != nil {
+			log.Println(err)
+		}
+		return domain.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	du := repo.toDomainWithoutPassword(u)
 	err = repo.cache.Set(ctx, du)
 	// redis 可能是网络问题，或者本身崩掉了
 	if err != nil {
@@ -80,8 +244,43 @@ func (repo *CachedUserRepository) FindById(ctx context.Context, uid int64) (doma
 	return du, nil
 }
 
+// waitForRebuild 隔一小段时间读一次缓存，读几次还没有就放弃
+func (repo *CachedUserRepository) waitForRebuild(ctx context.Context, uid int64) (domain.User, error) {
+	const (
+		retries  = 3
+		interval = 50 * time.Millisecond
+	)
+	for i := 0; i < retries; i++ {
+		select {
+		case <-ctx.Done():
+			return domain.User{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		du, err := repo.cache.Get(ctx, uid)
+		if err == nil {
+			return du, nil
+		}
+		if errors.Is(err, cache.ErrUserNotExist) {
+			return domain.User{}, ErrUserNotFound
+		}
+	}
+	return domain.User{}, cache.ErrKeyNotExist
+}
+
+func (repo *CachedUserRepository) FindByIdWithPassword(ctx context.Context, uid int64) (domain.User, error) {
+	u, err := repo.dao.FindById(ctx, uid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return repo.toDomain(u), nil
+}
+
 func (repo *CachedUserRepository) UpdateNonZeroFields(ctx context.Context, user domain.User) error {
-	return repo.dao.UpdateByUserId(ctx, repo.toDaoEntity(user))
+	err := repo.dao.UpdateByUserId(ctx, repo.toDaoEntity(user))
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, user.Id)
 }
 
 // FindByPhone 根据电话号码查询用户信息
@@ -93,20 +292,240 @@ func (repo *CachedUserRepository) FindByPhone(ctx context.Context, phone string)
 	return repo.toDomain(u), nil
 }
 
+// UpdatePassword 更新密码
+// 登录校验依赖缓存里的 TokenVersion，所以更新完之后要立刻删掉缓存，不然旧 token 在缓存过期前还能用
+func (repo *CachedUserRepository) UpdatePassword(ctx context.Context, uid int64, password string) error {
+	err := repo.dao.UpdatePassword(ctx, uid, password)
+	if err != nil {
+		return err
+	}
+	return repo.invalidateOrFail(ctx, uid)
+}
+
+// RehashPassword 升级密码的哈希
+func (repo *CachedUserRepository) RehashPassword(ctx context.Context, uid int64, oldHash string, newHash string) error {
+	err := repo.dao.RehashPassword(ctx, uid, oldHash, newHash)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// UpdatePhone 换绑手机号
+func (repo *CachedUserRepository) UpdatePhone(ctx context.Context, uid int64, phone string) error {
+	err := repo.dao.UpdatePhone(ctx, uid, phone)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// UpdateEmail 换绑邮箱
+func (repo *CachedUserRepository) UpdateEmail(ctx context.Context, uid int64, email string) error {
+	err := repo.dao.UpdateEmail(ctx, uid, email)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// UpdateAvatar 更新头像
+func (repo *CachedUserRepository) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	err := repo.dao.UpdateAvatar(ctx, uid, avatar)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// UpdatePrivacy 更新隐私设置
+func (repo *CachedUserRepository) UpdatePrivacy(ctx context.Context, uid int64, privacy domain.PrivacySettings) error {
+	err := repo.dao.UpdatePrivacy(ctx, dao.User{
+		Id:             uid,
+		HideBirthday:   privacy.HideBirthday,
+		HideAboutMe:    privacy.HideAboutMe,
+		HideFromSearch: privacy.HideFromSearch,
+	})
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// Search 管理后台查询用户，直接查数据库
+func (repo *CachedUserRepository) Search(ctx context.Context, q domain.UserSearch) ([]domain.User, error) {
+	dq := dao.UserSearch{
+		HasPhone:       q.HasPhone,
+		HasEmail:       q.HasEmail,
+		NicknamePrefix: q.NicknamePrefix,
+		SortByCtime:    q.SortBy == domain.UserSortByCtime,
+		Desc:           q.Desc,
+		Limit:          q.Limit,
+	}
+	if !q.CtimeStart.IsZero() {
+		dq.CtimeStart = q.CtimeStart.UnixMilli()
+	}
+	if !q.CtimeEnd.IsZero() {
+		dq.CtimeEnd = q.CtimeEnd.UnixMilli()
+	}
+	if q.After != nil {
+		dq.After = &dao.UserSearchCursor{
+			Ctime: q.After.Ctime.UnixMilli(),
+			Id:    q.After.Id,
+		}
+	}
+
+	users, err := repo.dao.Search(ctx, dq)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.User, 0, len(users))
+	for _, u := range users {
+		res = append(res, repo.toDomain(u))
+	}
+	return res, nil
+}
+
+// Delete 注销用户，删除之后要把缓存也删掉，不然缓存过期之前还能查到
+func (repo *CachedUserRepository) Delete(ctx context.Context, uid int64) error {
+	err := repo.dao.Delete(ctx, uid)
+	if err != nil {
+		return err
+	}
+	return repo.invalidateOrFail(ctx, uid)
+}
+
+// PurgeDeleted 彻底删除保留期已过的注销用户，这些用户早就不在缓存里了
+// 返回删除的用户，只有 Id 和 Avatar
+func (repo *CachedUserRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]domain.User, error) {
+	users, err := repo.dao.PurgeDeleted(ctx, before, limit)
+	res := make([]domain.User, 0, len(users))
+	for _, u := range users {
+		res = append(res, domain.User{Id: u.Id, Avatar: u.Avatar})
+	}
+	return res, err
+}
+
+// BindPhone 给用户绑定手机号
+func (repo *CachedUserRepository) BindPhone(ctx context.Context, uid int64, phone string) error {
+	err := repo.dao.BindPhone(ctx, uid, phone)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// BindEmail 给用户绑定邮箱
+func (repo *CachedUserRepository) BindEmail(ctx context.Context, uid int64, email string) error {
+	err := repo.dao.BindEmail(ctx, uid, email)
+	if err != nil {
+		return err
+	}
+	return repo.invalidate(ctx, uid)
+}
+
+// Merge 把 source 账号合并到 target 账号，两个账号的缓存都要删掉，source 的旧 token 不能再用
+func (repo *CachedUserRepository) Merge(ctx context.Context, targetId int64, sourceId int64) error {
+	err := repo.dao.Merge(ctx, targetId, sourceId)
+	if err != nil {
+		return err
+	}
+	return repo.invalidateOrFail(ctx, targetId, sourceId)
+}
+
 /* --- 一些内部用的工具方法 --- */
 
+// invalidate 先写数据库再删缓存，下一次 FindById 会从数据库重新加载
+// 数据库已经写成功了，删缓存失败也不能当成这次修改失败，只打日志，最多就是缓存过期前读到旧数据
+func (repo *CachedUserRepository) invalidate(ctx context.Context, uids ...int64) error {
+	for _, uid := range uids {
+		if err := repo.cache.Del(ctx, uid); err != nil {
+			log.Printf("删除用户 %d 的缓存失败：%v", uid, err)
+		}
+	}
+	repo.delayedInvalidate(uids)
+	return nil
+}
+
+// invalidateOrFail 改密码、注销之类安全相关的修改用，缓存里的旧数据会让旧 token 在缓存过期前继续可用，
+// 所以删缓存失败要重试，重试之后还是失败就返回错误，让调用方知道修改还没有完全生效
+func (repo *CachedUserRepository) invalidateOrFail(ctx context.Context, uids ...int64) error {
+	// 延迟删除不受这次重试的结果影响，Redis 恢复之后还有机会删掉
+	defer repo.delayedInvalidate(uids)
+	for _, uid := range uids {
+		err := retry(ctx, fmt.Sprintf("删除用户 %d 的缓存", uid), func() error {
+			return repo.cache.Del(ctx, uid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retry 访问 Redis 失败的时候重试几次，都失败了就返回最后一次的错误
+func retry(ctx context.Context, what string, fn func() error) error {
+	var err error
+	for i := 0; i < redisRetries; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		log.Printf("%s失败，第 %d 次：%v", what, i+1, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(redisRetryInterval):
+		}
+	}
+	return fmt.Errorf("%s失败：%w", what, err)
+}
+
+// delayedInvalidate 延迟双删，没有配置 DoubleDeleteDelay 的时候什么都不做
+func (repo *CachedUserRepository) delayedInvalidate(uids []int64) {
+	if repo.opts.DoubleDeleteDelay <= 0 {
+		return
+	}
+	time.AfterFunc(repo.opts.DoubleDeleteDelay, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for _, uid := range uids {
+			if err := repo.cache.Del(ctx, uid); err != nil {
+				log.Printf("延迟删除用户 %d 的缓存失败：%v", uid, err)
+			}
+		}
+	})
+}
+
 func (repo *CachedUserRepository) toDomain(u dao.User) domain.User {
 	return domain.User{
-		Id:       u.Id,
-		Email:    u.Email.String,
-		Phone:    u.Phone.String,
-		Password: u.Password,
-		Nickname: u.Nickname,
-		Birthday: time.UnixMilli(u.Birthday),
-		AboutMe:  u.AboutMe,
+		Id:           u.Id,
+		Email:        u.Email.String,
+		Phone:        u.Phone.String,
+		Password:     u.Password,
+		Nickname:     u.Nickname,
+		Birthday:     domain.BirthdayFromMillis(u.Birthday),
+		AboutMe:      u.AboutMe,
+		Avatar:       u.Avatar,
+		TokenVersion: u.TokenVersion,
+		Version:      u.Version,
+		Ctime:        time.UnixMilli(u.Ctime),
+		Utime:        time.UnixMilli(u.Utime),
+		Privacy: domain.PrivacySettings{
+			HideBirthday:   u.HideBirthday,
+			HideAboutMe:    u.HideAboutMe,
+			HideFromSearch: u.HideFromSearch,
+		},
+		Role: domain.UserRole(u.Role),
 	}
 }
 
+// toDomainWithoutPassword 缓存里没有密码哈希，从数据库查出来的也去掉，不然 FindById 的结果时有时无
+func (repo *CachedUserRepository) toDomainWithoutPassword(u dao.User) domain.User {
+	du := repo.toDomain(u)
+	du.Password = ""
+	return du
+}
+
 func (repo *CachedUserRepository) toDaoEntity(u domain.User) dao.User {
 	return dao.User{
 		Id: u.Id,
@@ -119,8 +538,9 @@ func (repo *CachedUserRepository) toDaoEntity(u domain.User) dao.User {
 			Valid:  u.Phone != "",
 		},
 		Password: u.Password,
-		Birthday: u.Birthday.UnixMilli(),
+		Birthday: domain.BirthdayToMillis(u.Birthday),
 		AboutMe:  u.AboutMe,
 		Nickname: u.Nickname,
+		Version:  u.Version,
 	}
 }
@@ -0,0 +1,481 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mini-ebook/internal/domain"
+	"mini-ebook/internal/repository/cache"
+	"mini-ebook/internal/repository/dao"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryUserDAO 内存实现，只实现了测试里用到的方法
+type memoryUserDAO struct {
+	dao.UserDAO
+	users map[int64]dao.User
+	// findCnt FindById 被调用了多少次
+	findCnt int
+	// findDelay 模拟慢查询
+	findDelay time.Duration
+}
+
+func (d *memoryUserDAO) Insert(ctx context.Context, u dao.User) (int64, error) {
+	u.Id = int64(len(d.users) + 1)
+	d.users[u.Id] = u
+	return u.Id, nil
+}
+
+func (d *memoryUserDAO) FindById(ctx context.Context, uid int64) (dao.User, error) {
+	d.findCnt++
+	select {
+	case <-time.After(d.findDelay):
+	case <-ctx.Done():
+		return dao.User{}, ctx.Err()
+	}
+	u, ok := d.users[uid]
+	if !ok {
+		return dao.User{}, dao.ErrRecordNotFound
+	}
+	return u, nil
+}
+
+func (d *memoryUserDAO) UpdateByUserId(ctx context.Context, entity dao.User) error {
+	u := d.users[entity.Id]
+	u.Nickname, u.Birthday, u.AboutMe = entity.Nickname, entity.Birthday, entity.AboutMe
+	d.users[entity.Id] = u
+	return nil
+}
+
+func (d *memoryUserDAO) UpdatePassword(ctx context.Context, uid int64, password string) error {
+	u := d.users[uid]
+	u.Password = password
+	u.TokenVersion++
+	d.users[uid] = u
+	return nil
+}
+
+func (d *memoryUserDAO) BindPhone(ctx context.Context, uid int64, phone string) error {
+	u := d.users[uid]
+	u.Phone = sql.NullString{String: phone, Valid: true}
+	d.users[uid] = u
+	return nil
+}
+
+func (d *memoryUserDAO) Merge(ctx context.Context, targetId int64, sourceId int64) error {
+	u := d.users[targetId]
+	u.Phone = d.users[sourceId].Phone
+	d.users[targetId] = u
+	delete(d.users, sourceId)
+	return nil
+}
+
+func (d *memoryUserDAO) Delete(ctx context.Context, uid int64) error {
+	delete(d.users, uid)
+	return nil
+}
+
+// memoryUserCache 内存实现，只在测试里用
+type memoryUserCache struct {
+	mu       sync.Mutex
+	users    map[int64]domain.User
+	notFound map[int64]bool
+	// getErr 模拟 Redis 不可用
+	getErr error
+	// delFailures 接下来的多少次 Del 会失败
+	delFailures int
+}
+
+func (c *memoryUserCache) Get(ctx context.Context, uid int64) (domain.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.getErr != nil {
+		return domain.User{}, c.getErr
+	}
+	if c.notFound[uid] {
+		return domain.User{}, cache.ErrUserNotExist
+	}
+	u, ok := c.users[uid]
+	if !ok {
+		return domain.User{}, cache.ErrKeyNotExist
+	}
+	return u, nil
+}
+
+func (c *memoryUserCache) Set(ctx context.Context, du domain.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[du.Id] = du
+	return nil
+}
+
+func (c *memoryUserCache) SetNotFound(ctx context.Context, uid int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notFound[uid] = true
+	return nil
+}
+
+func (c *memoryUserCache) Del(ctx context.Context, uid int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.delFailures > 0 {
+		c.delFailures--
+		return errors.New("模拟 Redis 不可用")
+	}
+	delete(c.users, uid)
+	delete(c.notFound, uid)
+	return nil
+}
+
+func (c *memoryUserCache) has(uid int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.users[uid]
+	return ok
+}
+
+func newTestUserRepository(delay time.Duration) (UserRepository, *memoryUserCache) {
+	repo, _, c := newTestUserRepositoryWithDAO(delay)
+	return repo, c
+}
+
+func newTestUserRepositoryWithDAO(delay time.Duration) (UserRepository, *memoryUserDAO, *memoryUserCache) {
+	d := &memoryUserDAO{users: map[int64]dao.User{
+		1: {Id: 1, Nickname: "old", Password: "old"},
+		2: {Id: 2, Phone: sql.NullString{String: "+8613800000000", Valid: true}},
+	}}
+	c := &memoryUserCache{users: map[int64]domain.User{}, notFound: map[int64]bool{}}
+	return NewCachedUserRepositoryWithOptions(d, c, CachedUserRepositoryOptions{DoubleDeleteDelay: delay}), d, c
+}
+
+func TestCachedUserRepository_ReadAfterWrite(t *testing.T) {
+	testCases := []struct {
+		name   string
+		write  func(ctx context.Context, repo UserRepository) error
+		verify func(t *testing.T, u domain.User)
+	}{
+		{
+			name: "修改资料",
+			write: func(ctx context.Context, repo UserRepository) error {
+				return repo.UpdateUserInfo(ctx, domain.User{Id: 1, Nickname: "new"})
+			},
+			verify: func(t *testing.T, u domain.User) {
+				if u.Nickname != "new" {
+					t.Fatalf("期望昵称 new，实际 %s", u.Nickname)
+				}
+			},
+		},
+		{
+			name: "只修改非零字段",
+			write: func(ctx context.Context, repo UserRepository) error {
+				return repo.UpdateNonZeroFields(ctx, domain.User{Id: 1, Nickname: "new"})
+			},
+			verify: func(t *testing.T, u domain.User) {
+				if u.Nickname != "new" {
+					t.Fatalf("期望昵称 new，实际 %s", u.Nickname)
+				}
+			},
+		},
+		{
+			name: "修改密码",
+			write: func(ctx context.Context, repo UserRepository) error {
+				return repo.UpdatePassword(ctx, 1, "new")
+			},
+			verify: func(t *testing.T, u domain.User) {
+				if u.TokenVersion != 1 {
+					t.Fatalf("期望 TokenVersion 1，实际 %d", u.TokenVersion)
+				}
+				if u.Password != "" {
+					t.Fatal("FindById 不应该返回密码哈希")
+				}
+			},
+		},
+		{
+			name: "绑定手机号",
+			write: func(ctx context.Context, repo UserRepository) error {
+				return repo.BindPhone(ctx, 1, "+8613900000000")
+			},
+			verify: func(t *testing.T, u domain.User) {
+				if u.Phone != "+8613900000000" {
+					t.Fatalf("期望手机号 +8613900000000，实际 %s", u.Phone)
+				}
+			},
+		},
+		{
+			name: "合并账号",
+			write: func(ctx context.Context, repo UserRepository) error {
+				return repo.Merge(ctx, 1, 2)
+			},
+			verify: func(t *testing.T, u domain.User) {
+				if u.Phone != "+8613800000000" {
+					t.Fatalf("期望合并过来的手机号，实际 %s", u.Phone)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, _ := newTestUserRepository(0)
+			ctx := context.Background()
+			// 先读一次，让用户进缓存
+			if _, err := repo.FindById(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.FindById(ctx, 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.write(ctx, repo); err != nil {
+				t.Fatal(err)
+			}
+			u, err := repo.FindById(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.verify(t, u)
+		})
+	}
+}
+
+func TestCachedUserRepository_DeleteThenRead(t *testing.T) {
+	repo, _ := newTestUserRepository(0)
+	ctx := context.Background()
+	if _, err := repo.FindById(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindById(ctx, 1); err != ErrUserNotFound {
+		t.Fatalf("期望 ErrUserNotFound，实际 %v", err)
+	}
+}
+
+func TestCachedUserRepository_DoubleDelete(t *testing.T) {
+	repo, c := newTestUserRepository(20 * time.Millisecond)
+	ctx := context.Background()
+	if err := repo.UpdateUserInfo(ctx, domain.User{Id: 1, Nickname: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟并发的读请求在删缓存之后把旧数据写回了缓存
+	_ = c.Set(ctx, domain.User{Id: 1, Nickname: "old"})
+
+	time.Sleep(100 * time.Millisecond)
+	if c.has(1) {
+		t.Fatal("延迟双删之后缓存里不应该还有旧数据")
+	}
+	u, err := repo.FindById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Nickname != "new" {
+		t.Fatalf("期望昵称 new，实际 %s", u.Nickname)
+	}
+}
+
+func TestCachedUserRepository_UpdatePasswordInvalidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		delFailures int
+		wantErr     bool
+	}{
+		{name: "删缓存成功", delFailures: 0},
+		{name: "重试之后删掉了", delFailures: redisRetries - 1},
+		{name: "一直删不掉", delFailures: redisRetries, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, c := newTestUserRepository(0)
+			ctx := context.Background()
+			if _, err := repo.FindById(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			c.mu.Lock()
+			c.delFailures = tc.delFailures
+			c.mu.Unlock()
+
+			// 改密码不能只打日志，缓存里的旧 TokenVersion 会让旧 token 继续可用
+			err := repo.UpdatePassword(ctx, 1, "new")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("期望返回错误 %v，实际 %v", tc.wantErr, err)
+			}
+			if c.has(1) != tc.wantErr {
+				t.Fatalf("期望缓存还在 %v，实际 %v", tc.wantErr, c.has(1))
+			}
+		})
+	}
+}
+
+// memoryUserBloom 内存实现的布隆过滤器，addFailures 模拟前几次 Add 失败
+type memoryUserBloom struct {
+	uids        map[int64]bool
+	addFailures int
+}
+
+func (b *memoryUserBloom) Add(ctx context.Context, uid int64) error {
+	if b.addFailures > 0 {
+		b.addFailures--
+		return errors.New("模拟 Redis 出错")
+	}
+	b.uids[uid] = true
+	return nil
+}
+
+func (b *memoryUserBloom) MightContain(ctx context.Context, uid int64) (bool, error) {
+	return b.uids[uid], nil
+}
+
+func TestCachedUserRepository_CreateBloom(t *testing.T) {
+	testCases := []struct {
+		name        string
+		addFailures int
+		wantErr     bool
+	}{
+		{name: "加入成功", addFailures: 0},
+		{name: "重试之后加进去了", addFailures: redisRetries - 1},
+		{name: "一直加不进去", addFailures: redisRetries, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &memoryUserDAO{users: map[int64]dao.User{}}
+			c := &memoryUserCache{users: map[int64]domain.User{}, notFound: map[int64]bool{}}
+			b := &memoryUserBloom{uids: map[int64]bool{}, addFailures: tc.addFailures}
+			repo := NewCachedUserRepositoryWithOptions(d, c, CachedUserRepositoryOptions{Bloom: b})
+			ctx := context.Background()
+
+			// 加不进布隆过滤器的用户以后都查不到，要告诉调用方注册失败了
+			err := repo.Create(ctx, domain.User{Nickname: "new"})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("期望返回错误 %v，实际 %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			u, err := repo.FindById(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Nickname != "new" {
+				t.Fatalf("查到的用户不对 %+v", u)
+			}
+		})
+	}
+}
+
+func TestCachedUserRepository_NoBirthday(t *testing.T) {
+	repo, _ := newTestUserRepository(0)
+	u, err := repo.FindById(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 数据库里是 0，不能当成 1970-01-01
+	if !u.Birthday.IsZero() {
+		t.Fatalf("没有填生日期望零值，实际 %v", u.Birthday)
+	}
+}
+
+func TestCachedUserRepository_NotFound(t *testing.T) {
+	repo, d, _ := newTestUserRepositoryWithDAO(0)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := repo.FindById(ctx, 3); err != ErrUserNotFound {
+			t.Fatalf("期望 ErrUserNotFound，实际 %v", err)
+		}
+	}
+	if d.findCnt != 1 {
+		t.Fatalf("不存在的用户应该只查一次数据库，实际 %d 次", d.findCnt)
+	}
+
+	// 新注册的用户 id 正好是之前缓存过不存在的
+	if err := repo.Create(ctx, domain.User{Nickname: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := repo.FindById(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Nickname != "new" {
+		t.Fatalf("期望昵称 new，实际 %s", u.Nickname)
+	}
+}
+
+func TestCachedUserRepository_ConcurrentMiss(t *testing.T) {
+	repo, d, _ := newTestUserRepositoryWithDAO(0)
+	d.findDelay = 50 * time.Millisecond
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := repo.FindById(ctx, 1)
+			if err != nil || u.Id != 1 {
+				t.Errorf("期望查到用户 1，实际 %v %v", u, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d.findCnt != 1 {
+		t.Fatalf("并发的缓存未命中应该只查一次数据库，实际 %d 次", d.findCnt)
+	}
+}
+
+func TestCachedUserRepository_RebuildCallerCanceled(t *testing.T) {
+	repo, d, c := newTestUserRepositoryWithDAO(0)
+	d.findDelay = 50 * time.Millisecond
+
+	// 第一个请求发起了重建，然后自己超时了
+	errCh := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := repo.FindById(ctx, 1)
+		errCh <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	// 共享这次重建的其它请求不受影响
+	u, err := repo.FindById(context.Background(), 1)
+	if err != nil || u.Id != 1 {
+		t.Fatalf("期望查到用户 1，实际 %v %v", u, err)
+	}
+	if err = <-errCh; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("超时的请求期望 context.DeadlineExceeded，实际 %v", err)
+	}
+	if d.findCnt != 1 || !c.has(1) {
+		t.Fatalf("期望只查一次数据库并写回缓存，实际查了 %d 次", d.findCnt)
+	}
+}
+
+func TestCachedUserRepository_Degraded(t *testing.T) {
+	d := &memoryUserDAO{
+		users:     map[int64]dao.User{1: {Id: 1}},
+		findDelay: 50 * time.Millisecond,
+	}
+	c := &memoryUserCache{getErr: errors.New("连接被拒绝")}
+	repo := NewCachedUserRepositoryWithOptions(d, c, CachedUserRepositoryOptions{DegradeConcurrency: 1})
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, err := repo.FindById(ctx, 1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// 唯一的名额被占用了，第二个请求直接降级
+	if _, err := repo.FindById(ctx, 1); err != ErrDegraded {
+		t.Fatalf("期望 ErrDegraded，实际 %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// 名额释放之后又可以查数据库了
+	if _, err := repo.FindById(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/storage"
+	"mini-ebook/pkg/imagex"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// MaxAvatarSize 头像文件的最大字节数
+	MaxAvatarSize = 5 << 20
+	// maxAvatarPixels 解码前先检查尺寸，避免一张很小的文件解码出来占用大量内存
+	maxAvatarPixels = 4096 * 4096
+)
+
+var (
+	ErrAvatarTooLarge        = errors.New("头像文件太大")
+	ErrAvatarUnsupportedType = errors.New("不支持的头像格式")
+)
+
+// avatarSizes 生成的头像尺寸，第一个是用户资料里展示的头像
+var avatarSizes = []int{256, 64}
+
+type AvatarService interface {
+	// Upload 校验并处理上传的头像，保存之后更新用户的头像，返回头像的 URL
+	Upload(ctx context.Context, uid int64, data []byte) (string, error)
+	// Delete 删除 avatar 这个头像所有尺寸的文件，avatar 是用户资料里保存的 URL
+	Delete(ctx context.Context, uid int64, avatar string) error
+}
+
+type avatarService struct {
+	repo    repository.UserRepository
+	storage storage.Service
+}
+
+func NewAvatarService(repo repository.UserRepository, storageSvc storage.Service) AvatarService {
+	return &avatarService{
+		repo:    repo,
+		storage: storageSvc,
+	}
+}
+
+func (svc *avatarService) Upload(ctx context.Context, uid int64, data []byte) (string, error) {
+	if len(data) > MaxAvatarSize {
+		return "", ErrAvatarTooLarge
+	}
+	// 不相信客户端传过来的 Content-Type，根据文件内容判断
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return "", ErrAvatarUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarUnsupportedType
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		return "", ErrAvatarTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarUnsupportedType
+	}
+
+	old, err := svc.repo.FindById(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	// 每次上传都换一个新的文件名，避免 CDN 或者浏览器缓存了旧的头像
+	name, err := svc.randomName()
+	if err != nil {
+		return "", err
+	}
+	square := imagex.CropSquare(img)
+	var avatar string
+	for i, size := range avatarSizes {
+		thumb := imagex.Resize(square, size, size)
+		// PNG 可能有透明背景，保留 PNG，其余的统一转成 JPEG
+		var (
+			buf bytes.Buffer
+			ext = "jpg"
+			ct  = "image/jpeg"
+		)
+		if contentType == "image/png" {
+			ext, ct = "png", "image/png"
+			err = png.Encode(&buf, thumb)
+		} else {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return "", err
+		}
+
+		key := fmt.Sprintf("avatars/%d/%s_%d.%s", uid, name, size, ext)
+		url, err := svc.storage.Put(ctx, key, buf.Bytes(), ct)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			avatar = url
+		}
+	}
+
+	if err = svc.repo.UpdateAvatar(ctx, uid, avatar); err != nil {
+		return "", err
+	}
+	// 新头像已经生效了，旧文件删不掉只会多占点空间，不影响这次上传
+	if err = svc.Delete(ctx, uid, old.Avatar); err != nil {
+		log.Printf("删除用户 %d 的旧头像失败 %v", uid, err)
+	}
+	return avatar, nil
+}
+
+func (svc *avatarService) Delete(ctx context.Context, uid int64, avatar string) error {
+	var errs []error
+	for _, key := range avatarKeys(uid, avatar) {
+		errs = append(errs, svc.storage.Delete(ctx, key))
+	}
+	return errors.Join(errs...)
+}
+
+// avatarKeys 根据头像的 URL 算出所有尺寸的对象 key，不是 Upload 生成的 URL 返回 nil
+// URL 的最后一段是 名字_尺寸.扩展名，只有第一个尺寸的 URL 会保存到用户资料里
+func avatarKeys(uid int64, avatar string) []string {
+	if !strings.Contains(avatar, fmt.Sprintf("/avatars/%d/", uid)) {
+		return nil
+	}
+	base := path.Base(avatar)
+	ext := path.Ext(base)
+	name, ok := strings.CutSuffix(base, fmt.Sprintf("_%d%s", avatarSizes[0], ext))
+	if !ok || name == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		keys = append(keys, fmt.Sprintf("avatars/%d/%s_%d%s", uid, name, size, ext))
+	}
+	return keys
+}
+
+func (svc *avatarService) randomName() (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	return hex.EncodeToString(buf), err
+}
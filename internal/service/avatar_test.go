@@ -0,0 +1,108 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mini-ebook/internal/domain"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/sms"
+	"mini-ebook/internal/service/storage/localStorage"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// avatarUserRepository 内存实现，只保存头像
+type avatarUserRepository struct {
+	repository.UserRepository
+	avatars map[int64]string
+	// purged PurgeDeleted 返回的用户
+	purged []domain.User
+}
+
+func (r *avatarUserRepository) FindById(ctx context.Context, uid int64) (domain.User, error) {
+	return domain.User{Id: uid, Avatar: r.avatars[uid]}, nil
+}
+
+func (r *avatarUserRepository) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	r.avatars[uid] = avatar
+	return nil
+}
+
+func (r *avatarUserRepository) PurgeDeleted(ctx context.Context, before time.Time, limit int) ([]domain.User, error) {
+	res := r.purged
+	r.purged = nil
+	return res, nil
+}
+
+func testAvatar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// avatarFiles 本地存储目录下某个用户的所有头像文件
+func avatarFiles(t *testing.T, dir string, uid string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "avatars", uid, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestAvatarService_UploadDeletesOld(t *testing.T) {
+	dir := t.TempDir()
+	repo := &avatarUserRepository{avatars: map[int64]string{}}
+	svc := NewAvatarService(repo, localStorage.NewService(dir, "/static"))
+	ctx := context.Background()
+
+	first, err := svc.Upload(ctx, 1, testAvatar(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := svc.Upload(ctx, 1, testAvatar(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.avatars[1] != second {
+		t.Fatalf("期望头像是 %s，实际 %s", second, repo.avatars[1])
+	}
+	// 旧头像的所有尺寸都要删掉，只剩下新头像的
+	files := avatarFiles(t, dir, "1")
+	if len(files) != len(avatarSizes) {
+		t.Fatalf("期望剩下 %d 个文件，实际 %v", len(avatarSizes), files)
+	}
+	oldName := strings.TrimSuffix(filepath.Base(first), "_256.png")
+	for _, f := range files {
+		if strings.HasPrefix(filepath.Base(f), oldName) {
+			t.Fatalf("旧头像 %s 没有删掉", f)
+		}
+	}
+}
+
+func TestUserService_PurgeDeletedAvatars(t *testing.T) {
+	dir := t.TempDir()
+	repo := &avatarUserRepository{avatars: map[int64]string{}}
+	avatars := NewAvatarService(repo, localStorage.NewService(dir, "/static"))
+	ctx := context.Background()
+	avatar, err := avatars.Upload(ctx, 1, testAvatar(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.purged = []domain.User{{Id: 1, Avatar: avatar}, {Id: 2}}
+	svc := NewUserService(repo, nil, nil, nil, nil, sms.Templates{}, avatars)
+	n, err := svc.PurgeDeleted(ctx, time.Now())
+	if err != nil || n != 2 {
+		t.Fatalf("期望删除 2 个用户，实际 %d %v", n, err)
+	}
+	// 注销用户的头像是公开访问的，要一起删掉
+	if files := avatarFiles(t, dir, "1"); len(files) != 0 {
+		t.Fatalf("期望头像文件都删掉了，实际 %v", files)
+	}
+}
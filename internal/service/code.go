@@ -2,10 +2,11 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"math/rand"
+	"mini-ebook/internal/domain"
 	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/mail"
 	"mini-ebook/internal/service/sms"
 )
 
@@ -13,18 +14,24 @@ var ErrorCodeSendTooMany = repository.ErrCodeSendTooMany
 
 type CodeService interface {
 	Send(ctx context.Context, biz string, phone string) error
-	Verify(ctx context.Context, biz, phone, inputCode string) (bool, error)
+	// SendEmail 和 Send 一样，只不过验证码是通过邮件发送的，校验同样用 Verify
+	SendEmail(ctx context.Context, biz string, email string) error
+	Verify(ctx context.Context, biz, phone, inputCode string) (domain.CodeVerifyResult, error)
 }
 
 type codeService struct {
 	repo repository.CodeRepository
 	sms  sms.Service
+	mail mail.Service
+	tpls sms.Templates
 }
 
-func NewCodeService(repo repository.CodeRepository, smsSvc sms.Service) CodeService {
+func NewCodeService(repo repository.CodeRepository, smsSvc sms.Service, mailSvc mail.Service, tpls sms.Templates) CodeService {
 	return &codeService{
 		repo: repo,
 		sms:  smsSvc,
+		mail: mailSvc,
+		tpls: tpls,
 	}
 }
 
@@ -36,18 +43,23 @@ func (svc *codeService) Send(ctx context.Context, biz string, phone string) erro
 	if err != nil {
 		return err
 	}
-	const codeTplId = "1877556"
-	return svc.sms.Send(ctx, codeTplId, []string{code}, phone)
+	return svc.sms.Send(ctx, svc.tpls.Code, []string{code}, phone)
 }
 
-// Verify 验证验证码
-func (svc *codeService) Verify(ctx context.Context, biz, phone, inputCode string) (bool, error) {
-	ok, err := svc.repo.Verify(ctx, biz, phone, inputCode)
-	if errors.Is(err, repository.ErrCodeVerifyTooMany) {
-		// 相当于，我们对外面屏蔽了验证次数过多的错误，只是告诉调用者，你这个不对
-		return false, nil
+// SendEmail 生成一个随机验证码，并通过邮件发送
+func (svc *codeService) SendEmail(ctx context.Context, biz string, email string) error {
+	code := svc.generate()
+	err := svc.repo.Set(ctx, biz, email, code)
+	if err != nil {
+		return err
 	}
-	return ok, err
+	const subject = "小微书验证码"
+	return svc.mail.Send(ctx, email, subject, fmt.Sprintf("你的验证码是 %s，10 分钟内有效", code))
+}
+
+// Verify 验证验证码，返回具体的校验结果，由调用者决定怎么提示用户
+func (svc *codeService) Verify(ctx context.Context, biz, phone, inputCode string) (domain.CodeVerifyResult, error) {
+	return svc.repo.Verify(ctx, biz, phone, inputCode)
 }
 
 func (svc *codeService) generate() string {
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/mail"
+	"strings"
+)
+
+var (
+	ErrMagicLinkSendTooMany = repository.ErrMagicLinkSendTooMany
+	ErrInvalidMagicLink     = errors.New("登录链接不合法或者已经失效")
+)
+
+// MagicLinkService 邮件登录链接
+type MagicLinkService interface {
+	// Send 生成一次性的登录链接，并发送到邮箱
+	Send(ctx context.Context, email string) error
+	// Verify 校验并消费 token，返回 token 对应的邮箱
+	Verify(ctx context.Context, token string) (string, error)
+}
+
+type magicLinkService struct {
+	repo repository.MagicLinkRepository
+	mail mail.Service
+	// key 签名 token 用的密钥
+	key []byte
+}
+
+func NewMagicLinkService(repo repository.MagicLinkRepository, mailSvc mail.Service, key []byte) MagicLinkService {
+	return &magicLinkService{
+		repo: repo,
+		mail: mailSvc,
+		key:  key,
+	}
+}
+
+func (svc *magicLinkService) Send(ctx context.Context, email string) error {
+	tokenId, err := svc.generateId()
+	if err != nil {
+		return err
+	}
+	err = svc.repo.Store(ctx, tokenId, email)
+	if err != nil {
+		return err
+	}
+
+	const (
+		subject = "登录小微书"
+		linkTpl = "https://mini-book.aqingcyan.com/login_email?token=%s"
+	)
+	link := fmt.Sprintf(linkTpl, svc.sign(tokenId))
+	body := fmt.Sprintf("点击下面的链接登录小微书，链接 15 分钟内有效，并且只能使用一次：\n%s", link)
+	return svc.mail.Send(ctx, email, subject, body)
+}
+
+func (svc *magicLinkService) Verify(ctx context.Context, token string) (string, error) {
+	// 先校验签名，伪造的 token 不用去查 Redis
+	tokenId, ok := svc.parse(token)
+	if !ok {
+		return "", ErrInvalidMagicLink
+	}
+	email, err := svc.repo.Consume(ctx, tokenId)
+	if errors.Is(err, repository.ErrMagicLinkNotFound) {
+		return "", ErrInvalidMagicLink
+	}
+	return email, err
+}
+
+func (svc *magicLinkService) generateId() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sign token 的格式是 tokenId.签名
+func (svc *magicLinkService) sign(tokenId string) string {
+	return tokenId + "." + svc.signature(tokenId)
+}
+
+func (svc *magicLinkService) parse(token string) (string, bool) {
+	tokenId, sig, found := strings.Cut(token, ".")
+	if !found || tokenId == "" {
+		return "", false
+	}
+	return tokenId, hmac.Equal([]byte(sig), []byte(svc.signature(tokenId)))
+}
+
+func (svc *magicLinkService) signature(tokenId string) string {
+	mac := hmac.New(sha256.New, svc.key)
+	mac.Write([]byte(tokenId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"mini-ebook/internal/repository"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sentMail 测试里记录下来的一封邮件
+type sentMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// memoryMailService 不发邮件，只是记录下来，测试的时候用来拿到邮件内容
+type memoryMailService struct {
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func (s *memoryMailService) Send(ctx context.Context, to string, subject string, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, sentMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+func (s *memoryMailService) Sent() []sentMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]sentMail, len(s.sent))
+	copy(res, s.sent)
+	return res
+}
+
+// memoryMagicLinkRepository 内存实现，只在测试里用
+type memoryMagicLinkRepository struct {
+	tokens map[string]string
+}
+
+func (r *memoryMagicLinkRepository) Store(ctx context.Context, tokenId, email string) error {
+	r.tokens[tokenId] = email
+	return nil
+}
+
+func (r *memoryMagicLinkRepository) Consume(ctx context.Context, tokenId string) (string, error) {
+	email, ok := r.tokens[tokenId]
+	if !ok {
+		return "", repository.ErrMagicLinkNotFound
+	}
+	delete(r.tokens, tokenId)
+	return email, nil
+}
+
+func TestMagicLinkService(t *testing.T) {
+	mailSvc := &memoryMailService{}
+	svc := NewMagicLinkService(&memoryMagicLinkRepository{tokens: map[string]string{}}, mailSvc,
+		[]byte("0123456789abcdef0123456789abcdef"))
+	ctx := context.Background()
+
+	if err := svc.Send(ctx, "a@qq.com"); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailSvc.Sent()
+	if len(sent) != 1 || sent[0].To != "a@qq.com" {
+		t.Fatalf("邮件没有发送到正确的邮箱 %v", sent)
+	}
+	_, token, found := strings.Cut(sent[0].Body, "token=")
+	if !found {
+		t.Fatalf("邮件里没有登录链接 %s", sent[0].Body)
+	}
+
+	// 篡改过的 token 不能通过
+	if _, err := svc.Verify(ctx, token+"x"); err != ErrInvalidMagicLink {
+		t.Fatalf("期望 ErrInvalidMagicLink，实际 %v", err)
+	}
+
+	email, err := svc.Verify(ctx, token)
+	if err != nil || email != "a@qq.com" {
+		t.Fatalf("期望 a@qq.com，实际 %s %v", email, err)
+	}
+
+	// 只能用一次
+	if _, err = svc.Verify(ctx, token); err != ErrInvalidMagicLink {
+		t.Fatalf("期望 ErrInvalidMagicLink，实际 %v", err)
+	}
+}
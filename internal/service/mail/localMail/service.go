@@ -0,0 +1,20 @@
+package localMail
+
+import (
+	"context"
+	"log"
+)
+
+// Service 还没有接入邮件服务商的时候用，不会真的发邮件，只打印收件人和标题
+// 正文里有登录链接、验证码之类的凭证，不能打到日志里
+type Service struct {
+}
+
+func NewService() *Service {
+	return &Service{}
+}
+
+func (s *Service) Send(ctx context.Context, to string, subject string, body string) error {
+	log.Println("邮件发送给", to, subject)
+	return nil
+}
@@ -0,0 +1,9 @@
+package mail
+
+import "context"
+
+// Service 发送邮件的抽象
+// 屏蔽不同邮件服务商（SMTP、SES 之类）之间的区别
+type Service interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
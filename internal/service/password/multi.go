@@ -0,0 +1,49 @@
+package password
+
+// MultiHasher 用 current 生成新的哈希，校验的时候根据哈希的格式找到对应的实现
+// 这样可以在不强制用户重置密码的情况下升级哈希算法
+type MultiHasher struct {
+	current Hasher
+	legacy  []Hasher
+}
+
+func NewMultiHasher(current Hasher, legacy ...Hasher) *MultiHasher {
+	return &MultiHasher{
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+func (h *MultiHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *MultiHasher) Verify(password string, encoded string) (bool, error) {
+	hasher, ok := h.find(encoded)
+	if !ok {
+		return false, ErrUnsupportedHash
+	}
+	return hasher.Verify(password, encoded)
+}
+
+// NeedsRehash 不是当前算法生成的，或者参数变了，都需要重新生成
+func (h *MultiHasher) NeedsRehash(encoded string) bool {
+	return !h.current.Supports(encoded) || h.current.NeedsRehash(encoded)
+}
+
+func (h *MultiHasher) Supports(encoded string) bool {
+	_, ok := h.find(encoded)
+	return ok
+}
+
+func (h *MultiHasher) find(encoded string) (Hasher, bool) {
+	if h.current.Supports(encoded) {
+		return h.current, true
+	}
+	for _, hasher := range h.legacy {
+		if hasher.Supports(encoded) {
+			return hasher, true
+		}
+	}
+	return nil, false
+}
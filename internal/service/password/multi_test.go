@@ -0,0 +1,52 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestMultiHasher(t *testing.T) {
+	params := Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	h := NewMultiHasher(NewArgon2idHasher(params), bcryptHasher)
+
+	encoded, err := h.Hash("hello@world123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("不是 PHC 格式的 argon2id 哈希 %s", encoded)
+	}
+	if ok, err := h.Verify("hello@world123", encoded); !ok || err != nil {
+		t.Fatalf("正确的密码校验失败 %v", err)
+	}
+	if ok, _ := h.Verify("hello@world124", encoded); ok {
+		t.Fatal("错误的密码校验通过了")
+	}
+	if h.NeedsRehash(encoded) {
+		t.Fatal("当前参数生成的哈希不需要重新生成")
+	}
+
+	// 旧的 bcrypt 哈希还能校验，但是需要升级
+	legacy, err := bcryptHasher.Hash("hello@world123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := h.Verify("hello@world123", legacy); !ok || err != nil {
+		t.Fatalf("旧的 bcrypt 哈希校验失败 %v", err)
+	}
+	if !h.NeedsRehash(legacy) {
+		t.Fatal("bcrypt 哈希需要升级成 argon2id")
+	}
+
+	// 参数调整之后，旧参数的哈希也需要升级
+	params.Iterations = 2
+	if !NewMultiHasher(NewArgon2idHasher(params)).NeedsRehash(encoded) {
+		t.Fatal("参数变化之后需要重新生成哈希")
+	}
+
+	if _, err = h.Verify("hello@world123", ""); err != ErrUnsupportedHash {
+		t.Fatalf("期望 ErrUnsupportedHash，实际 %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package password
+
+import "errors"
+
+var ErrUnsupportedHash = errors.New("不支持的密码哈希格式")
+
+// Hasher 密码哈希的抽象
+// 生成的哈希是自描述的（PHC 格式或者 bcrypt 自己的格式），算法和参数都编码在哈希里面
+type Hasher interface {
+	// Hash 生成密码的哈希
+	Hash(password string) (string, error)
+	// Verify 校验密码和哈希是否匹配，不认识的哈希格式返回 ErrUnsupportedHash
+	Verify(password string, encoded string) (bool, error)
+	// NeedsRehash 哈希的算法或者参数和当前的配置不一致，需要重新生成
+	NeedsRehash(encoded string) bool
+	// Supports 这个哈希是不是当前实现能处理的格式
+	Supports(encoded string) bool
+}
@@ -7,3 +7,11 @@ import "context"
 type Service interface {
 	Send(ctx context.Context, tplId string, args []string, number ...string) error
 }
+
+// Templates 各个业务用到的短信模板 id，模板要先在服务商那边审核通过，不同账号下的 id 不一样
+type Templates struct {
+	// Code 验证码，参数是验证码
+	Code string
+	// PhoneChanged 通知旧手机号已经换绑，参数是新手机号的后四位
+	PhoneChanged string
+}
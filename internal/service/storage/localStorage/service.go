@@ -0,0 +1,63 @@
+package localStorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("非法的对象 key")
+
+// Service 把对象保存在本地文件系统上，开发环境和单机部署用
+// 需要 web 服务器把 dir 映射到 baseURL 上
+type Service struct {
+	dir     string
+	baseURL string
+}
+
+func NewService(dir string, baseURL string) *Service {
+	return &Service{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *Service) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	key, path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *Service) Delete(ctx context.Context, key string) error {
+	_, path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path 清理 key，返回清理之后的 key 和对应的文件路径
+func (s *Service) path(key string) (string, string, error) {
+	// 防止 key 里面带 ../ 读写到 dir 外面去
+	key = filepath.ToSlash(filepath.Clean("/" + key))[1:]
+	if key == "" {
+		return "", "", ErrInvalidKey
+	}
+	return key, filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,12 @@
+package storage
+
+import "context"
+
+// Service 对象存储的抽象
+// 屏蔽本地文件系统、S3 兼容存储之间的区别
+type Service interface {
+	// Put 保存对象，返回可以直接访问的 URL
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Delete 删除对象，对象本来就不存在不算错误
+	Delete(ctx context.Context, key string) error
+}
@@ -3,61 +3,216 @@ package service
 import (
 	"context"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"fmt"
+	"log"
 	"mini-ebook/internal/domain"
 	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/mail"
+	"mini-ebook/internal/service/password"
+	"mini-ebook/internal/service/sms"
+	"strings"
+	"time"
 )
 
 var (
 	ErrDuplicateEmail        = repository.ErrDuplicateUser
 	ErrInvalidUserOrPassword = errors.New("用户不存在或是密码不正确")
+	ErrContactAlreadyBound   = repository.ErrContactAlreadyBound
+	ErrContactBoundToOther   = errors.New("手机号或者邮箱已经绑定了其他账号")
+	ErrMergeConflict         = repository.ErrMergeConflict
+	ErrVersionConflict       = repository.ErrVersionConflict
+	ErrUserNotFound          = repository.ErrUserNotFound
+	ErrLoginLocked           = repository.ErrLoginLocked
+	ErrLoginTooFrequent      = repository.ErrLoginTooFrequent
+	ErrLoginIPLocked         = errors.New("这个 IP 登录失败次数过多，暂时锁定")
+	ErrDegraded              = repository.ErrDegraded
 )
 
+// AnyUserVersion 修改资料的时候不检查版本号，见 repository.AnyUserVersion
+const AnyUserVersion = repository.AnyUserVersion
+
 type UserService interface {
 	Signup(ctx context.Context, u domain.User) error
-	Login(ctx context.Context, email string, password string) (domain.User, error)
+	// Login 邮箱密码登录，ip 用来做防暴力破解
+	Login(ctx context.Context, email string, password string, ip string) (domain.User, error)
 	UpdateUserInfo(ctx context.Context, user domain.User) error
 	UpdateNonSensitiveInfo(ctx context.Context, user domain.User) error
+	// FindInfoByUserId 优先读缓存，返回的用户不带密码哈希
 	FindInfoByUserId(ctx context.Context, uid int64) (domain.User, error)
+	// FindAccountByUserId 直接查数据库，带着密码哈希，比如导出数据的时候要知道有没有设置过密码
+	FindAccountByUserId(ctx context.Context, uid int64) (domain.User, error)
 	FindOrCreate(ctx context.Context, phone string) (domain.User, error)
+	FindOrCreateByEmail(ctx context.Context, email string) (domain.User, error)
+	// BindPhone 绑定一个已经验证过的手机号，merge 为 true 的时候，如果手机号属于另一个账号，就把那个账号合并过来
+	BindPhone(ctx context.Context, uid int64, phone string, merge bool) error
+	// BindEmail 绑定一个已经验证过的邮箱，merge 的含义同 BindPhone
+	BindEmail(ctx context.Context, uid int64, email string, merge bool) error
+	// ChangePassword 校验旧密码之后修改密码，之前签发的 token 全部失效，返回更新之后的用户
+	ChangePassword(ctx context.Context, uid int64, oldPassword string, newPassword string) (domain.User, error)
+	// ChangePhone 换成一个已经验证过的新手机号，并且通知旧手机号
+	ChangePhone(ctx context.Context, uid int64, phone string) error
+	// ChangeEmail 换成一个已经验证过的新邮箱，并且通知旧邮箱
+	ChangeEmail(ctx context.Context, uid int64, email string) error
+	// UpdatePrivacy 更新隐私设置
+	UpdatePrivacy(ctx context.Context, uid int64, privacy domain.PrivacySettings) error
+	// FindPublicProfile 其他人看到的资料，按照隐私设置隐藏对应的字段，手机号、邮箱之类的永远不会返回
+	FindPublicProfile(ctx context.Context, uid int64) (domain.User, error)
+	// SearchUsers 管理后台查询用户，调用方要自己确认是管理员
+	// 还有下一页的时候返回下一页的游标，没有的时候游标为 nil
+	SearchUsers(ctx context.Context, q domain.UserSearch) ([]domain.User, *domain.UserCursor, error)
+	// DeleteAccount 注销账号，数据会保留一段时间之后再彻底删除
+	DeleteAccount(ctx context.Context, uid int64) error
+	// PurgeDeleted 彻底删除在 before 之前注销的账号，返回删除的数量
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo        repository.UserRepository
+	attemptRepo repository.LoginAttemptRepository
+	hasher      password.Hasher
+	// sms 和 mail 用来通知用户账号信息发生了变化
+	sms  sms.Service
+	mail mail.Service
+	tpls sms.Templates
+	// avatars 彻底删除账号的时候一起删掉头像文件
+	avatars AvatarService
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepository, attemptRepo repository.LoginAttemptRepository,
+	hasher password.Hasher, smsSvc sms.Service, mailSvc mail.Service, tpls sms.Templates,
+	avatars AvatarService) UserService {
+	return &userService{
+		repo:        repo,
+		attemptRepo: attemptRepo,
+		hasher:      hasher,
+		sms:         smsSvc,
+		mail:        mailSvc,
+		tpls:        tpls,
+		avatars:     avatars,
+	}
 }
 
 func (svc *userService) Signup(ctx context.Context, u domain.User) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hash, err := svc.hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
 
-	u.Password = string(hash)
+	u.Password = hash
 
 	return svc.repo.Create(ctx, u)
 }
 
-func (svc *userService) Login(ctx context.Context, email string, password string) (domain.User, error) {
+func (svc *userService) Login(ctx context.Context, email string, password string, ip string) (domain.User, error) {
+	// 先检查账号和 IP 是不是因为失败次数太多被限制了，被限制了就不用再校验密码
+	for _, a := range svc.loginAttempts(email, ip) {
+		_, err := svc.attemptRepo.Check(ctx, a.biz, a.id, a.limit)
+		if a.biz == loginAttemptBizIP && errors.Is(err, ErrLoginLocked) {
+			// 只是 IP 被锁定了，账号本身没有被锁定，不能这么告诉用户
+			return domain.User{}, ErrLoginIPLocked
+		}
+		if err != nil {
+			return domain.User{}, err
+		}
+	}
+
 	u, err := svc.repo.FindByEmail(ctx, email)
 	if errors.Is(err, repository.ErrUserNotFound) {
+		// 用户不存在也要计数，不然可以通过是否被限制来判断邮箱有没有注册过
+		svc.loginFailed(ctx, email, ip)
 		return domain.User{}, ErrInvalidUserOrPassword
 	}
 	if err != nil {
 		return domain.User{}, err
 	}
 
-	// 检查密码是否匹配
-	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	if err != nil {
+	// 检查密码是否匹配，没有设置过密码（比如短信登录注册的用户）的哈希格式不认识，也当作密码不对
+	ok, err := svc.hasher.Verify(password, u.Password)
+	if err != nil || !ok {
+		svc.loginFailed(ctx, email, ip)
 		return domain.User{}, ErrInvalidUserOrPassword
 	}
+
+	// 只有在登录的时候才拿得到明文密码，顺便把旧算法的哈希升级成当前的算法
+	if svc.hasher.NeedsRehash(u.Password) {
+		svc.rehash(ctx, u, password)
+	}
+
+	// 登录成功只清空账号的计数，IP 的计数不清空
+	// 不然攻击者可以穿插登录一下自己的账号，来绕过 IP 维度的限制
+	err = svc.attemptRepo.Reset(ctx, loginAttemptBizAccount, loginAttemptAccountId(email))
+	if err != nil {
+		log.Println("清空登录失败计数失败", err)
+	}
 	return u, nil
 }
 
+// rehash 升级密码哈希，失败了也不影响这次登录，下次登录再试
+func (svc *userService) rehash(ctx context.Context, u domain.User, password string) {
+	hash, err := svc.hasher.Hash(password)
+	if err == nil {
+		err = svc.repo.RehashPassword(ctx, u.Id, u.Password, hash)
+	}
+	if err != nil {
+		log.Println("升级密码哈希失败", err)
+	}
+}
+
+const (
+	loginAttemptBizAccount = "account"
+	loginAttemptBizIP      = "ip"
+)
+
+type loginAttempt struct {
+	biz   string
+	id    string
+	limit repository.LoginAttemptLimit
+}
+
+// loginAttempts 登录失败从账号和 IP 两个维度限制
+// 同一个 IP 后面可能有很多正常用户（比如公司的出口 IP），所以 IP 的阈值要宽松很多
+func (svc *userService) loginAttempts(email string, ip string) []loginAttempt {
+	return []loginAttempt{
+		{
+			biz: loginAttemptBizAccount,
+			id:  loginAttemptAccountId(email),
+			limit: repository.LoginAttemptLimit{
+				DelayAfter:   3,
+				MaxDelay:     time.Minute,
+				LockAfter:    10,
+				LockDuration: time.Minute * 15,
+				Window:       time.Minute * 15,
+			},
+		},
+		{
+			biz: loginAttemptBizIP,
+			id:  ip,
+			limit: repository.LoginAttemptLimit{
+				DelayAfter:   20,
+				MaxDelay:     time.Second * 10,
+				LockAfter:    100,
+				LockDuration: time.Minute * 15,
+				Window:       time.Minute * 15,
+			},
+		},
+	}
+}
+
+// loginAttemptAccountId 账号维度的计数 key，大小写和前后空格不同的邮箱也算同一个账号，不然换个写法就能绕过限制
+func loginAttemptAccountId(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginFailed 记录登录失败，记录失败不影响返回给用户的结果
+func (svc *userService) loginFailed(ctx context.Context, email string, ip string) {
+	for _, a := range svc.loginAttempts(email, ip) {
+		err := svc.attemptRepo.Fail(ctx, a.biz, a.id, a.limit)
+		if err != nil {
+			log.Println("记录登录失败次数失败", err)
+		}
+	}
+}
+
 func (svc *userService) UpdateUserInfo(ctx context.Context, user domain.User) error {
 	return svc.repo.UpdateUserInfo(ctx, user)
 }
@@ -70,14 +225,18 @@ func (svc *userService) FindInfoByUserId(ctx context.Context, uid int64) (domain
 	return svc.repo.FindById(ctx, uid)
 }
 
+func (svc *userService) FindAccountByUserId(ctx context.Context, uid int64) (domain.User, error) {
+	return svc.repo.FindByIdWithPassword(ctx, uid)
+}
+
 func (svc *userService) FindOrCreate(ctx context.Context, phone string) (domain.User, error) {
 	// 先找一下，我们认为大部分用户是已经存在的用户
-	_, err := svc.repo.FindByPhone(ctx, phone)
+	u, err := svc.repo.FindByPhone(ctx, phone)
 	if !errors.Is(err, repository.ErrUserNotFound) {
 		// 有两种情况
 		// err == nil, u 是可用的
 		// err != nil, 系统错误
-		return domain.User{}, err
+		return u, err
 	}
 
 	// 如果没找到该用户
@@ -90,6 +249,204 @@ func (svc *userService) FindOrCreate(ctx context.Context, phone string) (domain.
 	}
 
 	// 要么 err == nil，要么 ErrDuplicateUser 也代表用户存在
-	// 考虑到生产环境有主从延迟，刚插入的数据不一定能立马查出来，所以这里理论上讲应该强制走主库
-	return svc.repo.FindByPhone(ctx, phone)
+	// 考虑到生产环境有主从延迟，刚插入的数据不一定能立马查出来，所以这里强制走主库
+	return svc.repo.FindByPhone(repository.ForcePrimary(ctx), phone)
+}
+
+// FindOrCreateByEmail 和 FindOrCreate 一样，只不过是按照邮箱来找，邮件链接登录的时候用
+func (svc *userService) FindOrCreateByEmail(ctx context.Context, email string) (domain.User, error) {
+	u, err := svc.repo.FindByEmail(ctx, email)
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return u, err
+	}
+
+	err = svc.repo.Create(ctx, domain.User{
+		Email: email,
+	})
+	if err != nil && !errors.Is(err, repository.ErrDuplicateUser) {
+		return domain.User{}, err
+	}
+
+	// 同样，这里也强制走主库
+	return svc.repo.FindByEmail(repository.ForcePrimary(ctx), email)
+}
+
+func (svc *userService) BindPhone(ctx context.Context, uid int64, phone string, merge bool) error {
+	err := svc.repo.BindPhone(ctx, uid, phone)
+	if !errors.Is(err, repository.ErrDuplicateUser) || !merge {
+		return svc.bindErr(err)
+	}
+	other, err := svc.repo.FindByPhone(ctx, phone)
+	if err != nil {
+		return err
+	}
+	return svc.repo.Merge(ctx, uid, other.Id)
+}
+
+func (svc *userService) BindEmail(ctx context.Context, uid int64, email string, merge bool) error {
+	err := svc.repo.BindEmail(ctx, uid, email)
+	if !errors.Is(err, repository.ErrDuplicateUser) || !merge {
+		return svc.bindErr(err)
+	}
+	other, err := svc.repo.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	return svc.repo.Merge(ctx, uid, other.Id)
+}
+
+// bindErr 唯一索引冲突对调用者来说，就是已经被别的账号绑定了
+func (svc *userService) bindErr(err error) error {
+	if errors.Is(err, repository.ErrDuplicateUser) {
+		return ErrContactBoundToOther
+	}
+	return err
+}
+
+func (svc *userService) ChangePassword(ctx context.Context, uid int64, oldPassword string, newPassword string) (domain.User, error) {
+	u, err := svc.repo.FindByIdWithPassword(ctx, uid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	ok, err := svc.hasher.Verify(oldPassword, u.Password)
+	if err != nil || !ok {
+		return domain.User{}, ErrInvalidUserOrPassword
+	}
+
+	hash, err := svc.hasher.Hash(newPassword)
+	if err != nil {
+		return domain.User{}, err
+	}
+	err = svc.repo.UpdatePassword(ctx, uid, hash)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return svc.repo.FindById(ctx, uid)
+}
+
+func (svc *userService) ChangePhone(ctx context.Context, uid int64, phone string) error {
+	u, err := svc.repo.FindById(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if u.Phone == phone {
+		return nil
+	}
+
+	err = svc.repo.UpdatePhone(ctx, uid, phone)
+	if err != nil {
+		return svc.bindErr(err)
+	}
+
+	if u.Phone != "" {
+		// 通知失败不影响换绑的结果，打个日志就行
+		err = svc.sms.Send(ctx, svc.tpls.PhoneChanged, []string{phone[len(phone)-4:]}, u.Phone)
+		if err != nil {
+			log.Println("通知旧手机号失败", err)
+		}
+	}
+	return nil
+}
+
+func (svc *userService) ChangeEmail(ctx context.Context, uid int64, email string) error {
+	u, err := svc.repo.FindById(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if u.Email == email {
+		return nil
+	}
+
+	err = svc.repo.UpdateEmail(ctx, uid, email)
+	if err != nil {
+		return svc.bindErr(err)
+	}
+
+	if u.Email != "" {
+		const subject = "小微书账号邮箱已修改"
+		body := fmt.Sprintf("你的小微书账号绑定的邮箱已经修改为 %s，如果不是你本人操作，请立即修改密码并联系我们", email)
+		err = svc.mail.Send(ctx, u.Email, subject, body)
+		if err != nil {
+			log.Println("通知旧邮箱失败", err)
+		}
+	}
+	return nil
+}
+
+func (svc *userService) DeleteAccount(ctx context.Context, uid int64) error {
+	return svc.repo.Delete(ctx, uid)
+}
+
+func (svc *userService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	// 分批删除，避免一个大事务长时间锁表
+	const batchSize = 500
+	var total int64
+	for {
+		users, err := svc.repo.PurgeDeleted(ctx, before, batchSize)
+		total += int64(len(users))
+		// 头像是公开访问的，账号删掉了头像也不能再留着
+		// 用户数据已经删掉了，头像删不掉没法重试，只能记下来人工处理
+		for _, u := range users {
+			if u.Avatar == "" {
+				continue
+			}
+			if aerr := svc.avatars.Delete(ctx, u.Id, u.Avatar); aerr != nil {
+				log.Printf("删除注销用户 %d 的头像 %s 失败 %v", u.Id, u.Avatar, aerr)
+			}
+		}
+		if err != nil || len(users) < batchSize {
+			return total, err
+		}
+	}
+}
+
+func (svc *userService) UpdatePrivacy(ctx context.Context, uid int64, privacy domain.PrivacySettings) error {
+	return svc.repo.UpdatePrivacy(ctx, uid, privacy)
+}
+
+func (svc *userService) FindPublicProfile(ctx context.Context, uid int64) (domain.User, error) {
+	u, err := svc.repo.FindById(ctx, uid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	// 只挑出可以公开的字段，以后 domain.User 加了新字段也不会不小心泄露出去
+	pub := domain.User{
+		Id:       u.Id,
+		Nickname: u.Nickname,
+		Avatar:   u.Avatar,
+		Birthday: u.Birthday,
+		AboutMe:  u.AboutMe,
+		Ctime:    u.Ctime,
+		Privacy:  u.Privacy,
+	}
+	if u.Privacy.HideBirthday {
+		pub.Birthday = time.Time{}
+	}
+	if u.Privacy.HideAboutMe {
+		pub.AboutMe = ""
+	}
+	return pub, nil
+}
+
+func (svc *userService) SearchUsers(ctx context.Context, q domain.UserSearch) ([]domain.User, *domain.UserCursor, error) {
+	const (
+		defaultLimit = 20
+		maxLimit     = 100
+	)
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	limit := min(q.Limit, maxLimit)
+	// 多查一条，用来判断是不是还有下一页
+	q.Limit = limit + 1
+	users, err := svc.repo.Search(ctx, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(users) <= limit {
+		return users, nil, nil
+	}
+	users = users[:limit]
+	last := users[limit-1]
+	return users, &domain.UserCursor{Ctime: last.Ctime, Id: last.Id}, nil
 }
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"mini-ebook/internal/domain"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service/password"
+	"mini-ebook/internal/service/sms"
+	"testing"
+	"time"
+)
+
+// memoryUserRepository 内存实现，只实现了测试里用到的方法
+type memoryUserRepository struct {
+	repository.UserRepository
+	users map[string]domain.User
+}
+
+func (r *memoryUserRepository) FindByEmail(ctx context.Context, email string) (domain.User, error) {
+	u, ok := r.users[email]
+	if !ok {
+		return domain.User{}, repository.ErrUserNotFound
+	}
+	return u, nil
+}
+
+// memoryLoginAttemptRepository 内存实现，失败次数到了 LockAfter 就锁定，不考虑时间
+type memoryLoginAttemptRepository struct {
+	fails map[string]int
+}
+
+func (r *memoryLoginAttemptRepository) Check(ctx context.Context, biz, id string, limit repository.LoginAttemptLimit) (time.Duration, error) {
+	if r.fails[biz+":"+id] >= limit.LockAfter {
+		return limit.LockDuration, repository.ErrLoginLocked
+	}
+	return 0, nil
+}
+
+func (r *memoryLoginAttemptRepository) Fail(ctx context.Context, biz, id string, limit repository.LoginAttemptLimit) error {
+	r.fails[biz+":"+id]++
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Reset(ctx context.Context, biz, id string) error {
+	delete(r.fails, biz+":"+id)
+	return nil
+}
+
+func newTestLoginService(t *testing.T) (UserService, *memoryLoginAttemptRepository) {
+	hasher := password.NewBcryptHasher(4)
+	hash, err := hasher.Hash("hello#world123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &memoryUserRepository{users: map[string]domain.User{
+		"a@qq.com": {Id: 1, Email: "a@qq.com", Password: hash},
+	}}
+	attempts := &memoryLoginAttemptRepository{fails: map[string]int{}}
+	return NewUserService(repo, attempts, hasher, nil, nil, sms.Templates{}, nil), attempts
+}
+
+func TestUserService_LoginAccountLocked(t *testing.T) {
+	svc, _ := newTestLoginService(t)
+	ctx := context.Background()
+	// 换着大小写和空格试密码，也算在同一个账号上
+	emails := []string{"a@qq.com", "A@qq.com", " a@QQ.com ", "a@Qq.com"}
+	for i := 0; i < 10; i++ {
+		_, err := svc.Login(ctx, emails[i%len(emails)], "wrong", "1.1.1.1")
+		if !errors.Is(err, ErrInvalidUserOrPassword) {
+			t.Fatalf("期望 ErrInvalidUserOrPassword，实际 %v", err)
+		}
+	}
+	if _, err := svc.Login(ctx, "a@qq.com", "hello#world123", "2.2.2.2"); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("期望账号被锁定，实际 %v", err)
+	}
+}
+
+func TestUserService_LoginIPLocked(t *testing.T) {
+	svc, attempts := newTestLoginService(t)
+	ctx := context.Background()
+	attempts.fails[loginAttemptBizIP+":1.1.1.1"] = 100
+
+	if _, err := svc.Login(ctx, "a@qq.com", "hello#world123", "1.1.1.1"); !errors.Is(err, ErrLoginIPLocked) {
+		t.Fatalf("期望 ErrLoginIPLocked，实际 %v", err)
+	}
+	// 账号本身没有被锁定，换个网络可以正常登录
+	u, err := svc.Login(ctx, "a@qq.com", "hello#world123", "2.2.2.2")
+	if err != nil || u.Id != 1 {
+		t.Fatalf("期望登录成功，实际 %v %v", u.Id, err)
+	}
+}
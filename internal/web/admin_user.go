@@ -0,0 +1,165 @@
+package web
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"mini-ebook/internal/domain"
+	"mini-ebook/internal/service"
+	"net/http"
+	"time"
+)
+
+// AdminUserHandler 管理后台的用户相关接口，只有管理员可以访问
+type AdminUserHandler struct {
+	svc service.UserService
+}
+
+func NewAdminUserHandler(svc service.UserService) *AdminUserHandler {
+	return &AdminUserHandler{
+		svc: svc,
+	}
+}
+
+func (h *AdminUserHandler) RegisterRoutes(server *gin.Engine) {
+	group := server.Group("/admin/users")
+	group.Use(h.checkAdmin)
+
+	group.GET("", h.List)
+}
+
+// checkAdmin 登录校验已经在全局的中间件里做过了，这里只检查是不是管理员
+func (h *AdminUserHandler) checkAdmin(ctx *gin.Context) {
+	us := ctx.MustGet("user").(UserClaims)
+	u, err := h.svc.FindInfoByUserId(ctx, us.Uid)
+	if err != nil {
+		log.Println(err)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if u.Role != domain.UserRoleAdmin {
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+}
+
+// List 按条件查询用户
+// 例如 /admin/users?ctimeStart=2023-10-01&hasPhone=true&nicknamePrefix=abc&sort=ctime&order=desc&limit=20
+// 翻页的时候带上上一页返回的 cursor
+func (h *AdminUserHandler) List(ctx *gin.Context) {
+	type Req struct {
+		// CtimeStart 和 CtimeEnd 的格式是 2006-01-02，左闭右开
+		CtimeStart     string `form:"ctimeStart"`
+		CtimeEnd       string `form:"ctimeEnd"`
+		HasPhone       *bool  `form:"hasPhone"`
+		HasEmail       *bool  `form:"hasEmail"`
+		NicknamePrefix string `form:"nicknamePrefix"`
+		// Sort 可以是 id 或者 ctime，默认是 id
+		Sort string `form:"sort"`
+		// Order 可以是 asc 或者 desc，默认是 asc
+		Order  string `form:"order"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+	var req Req
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "查询参数不正确",
+		})
+		return
+	}
+
+	q := domain.UserSearch{
+		HasPhone:       req.HasPhone,
+		HasEmail:       req.HasEmail,
+		NicknamePrefix: req.NicknamePrefix,
+		Desc:           req.Order == "desc",
+		Limit:          req.Limit,
+	}
+	var err error
+	switch req.Sort {
+	case "", "id":
+		q.SortBy = domain.UserSortById
+	case "ctime":
+		q.SortBy = domain.UserSortByCtime
+	default:
+		err = errors.New("不支持的排序字段")
+	}
+	if err == nil && req.CtimeStart != "" {
+		q.CtimeStart, err = time.ParseInLocation(time.DateOnly, req.CtimeStart, time.Local)
+	}
+	if err == nil && req.CtimeEnd != "" {
+		q.CtimeEnd, err = time.ParseInLocation(time.DateOnly, req.CtimeEnd, time.Local)
+	}
+	if err == nil && req.Cursor != "" {
+		q.After, err = h.decodeCursor(req.Cursor)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "查询参数不正确",
+		})
+		return
+	}
+
+	users, next, err := h.svc.SearchUsers(ctx, q)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+
+	type User struct {
+		Id       int64  `json:"id,string"`
+		Email    string `json:"email"`
+		Phone    string `json:"phone"`
+		Nickname string `json:"nickname"`
+		Ctime    string `json:"ctime"`
+	}
+	type Page struct {
+		Users []User `json:"users"`
+		// NextCursor 为空表示没有下一页了
+		NextCursor string `json:"nextCursor"`
+	}
+	page := Page{
+		Users: make([]User, 0, len(users)),
+	}
+	for _, u := range users {
+		page.Users = append(page.Users, User{
+			Id:       u.Id,
+			Email:    u.Email,
+			Phone:    u.Phone,
+			Nickname: u.Nickname,
+			Ctime:    u.Ctime.Format(time.DateTime),
+		})
+	}
+	if next != nil {
+		page.NextCursor = h.encodeCursor(*next)
+	}
+	ctx.JSON(http.StatusOK, Result{
+		Data: page,
+	})
+}
+
+// encodeCursor 游标对前端来说是不透明的字符串
+func (h *AdminUserHandler) encodeCursor(c domain.UserCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Ctime.UnixMilli(), c.Id)))
+}
+
+func (h *AdminUserHandler) decodeCursor(s string) (*domain.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var ctime, id int64
+	_, err = fmt.Sscanf(string(data), "%d:%d", &ctime, &id)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.UserCursor{Ctime: time.UnixMilli(ctime), Id: id}, nil
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// mergeTokenExpiration 用户看到提示之后决定要不要合并的时间
+const mergeTokenExpiration = time.Minute * 5
+
+// mergeTokenKey 和登录 token 用不同的密钥，两种 token 不能互相冒用
+var mergeTokenKey = func() []byte {
+	mac := hmac.New(sha256.New, JwtKey)
+	mac.Write([]byte("merge_token"))
+	return mac.Sum(nil)
+}()
+
+// MergeClaims 绑定的时候发现手机号、邮箱属于另一个账号，验证码已经用掉了，
+// 凭它证明验证码校验过，用户确认合并之后重新绑定不用再收一次验证码
+type MergeClaims struct {
+	jwt.RegisteredClaims
+	BindUid int64
+	Biz     string
+	Contact string
+}
+
+func (uh *UserHandler) signMergeToken(uid int64, biz string, contact string) (string, error) {
+	mc := MergeClaims{
+		BindUid: uid,
+		Biz:     biz,
+		Contact: contact,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mergeTokenExpiration)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS512, mc).SignedString(mergeTokenKey)
+}
+
+// checkMergeToken 只能由拿到它的用户，用来绑定同一个手机号或者邮箱
+func (uh *UserHandler) checkMergeToken(tokenStr string, uid int64, biz string, contact string) bool {
+	var mc MergeClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &mc, func(token *jwt.Token) (interface{}, error) {
+		return mergeTokenKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}))
+	if err != nil || !token.Valid {
+		return false
+	}
+	return mc.BindUid == uid && mc.Biz == biz && mc.Contact == contact
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"log"
+	"mini-ebook/internal/service"
 	"mini-ebook/internal/web"
 	"net/http"
 	"strings"
@@ -11,6 +13,14 @@ import (
 )
 
 type LoginJWTMiddlewareBuilder struct {
+	// svc 用来查询用户当前的 token 版本号
+	svc service.UserService
+}
+
+func NewLoginJWTMiddlewareBuilder(svc service.UserService) *LoginJWTMiddlewareBuilder {
+	return &LoginJWTMiddlewareBuilder{
+		svc: svc,
+	}
 }
 
 func (m *LoginJWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
@@ -19,7 +29,13 @@ func (m *LoginJWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 		if path == "/users/signup" ||
 			path == "/users/login" ||
 			path == "/users/login_sms/code/send" ||
-			path == "/users/login_sms" {
+			path == "/users/login_sms" ||
+			path == "/users/login_email/link/send" ||
+			path == "/users/login_email" ||
+			// 头像之类的静态资源是公开的
+			strings.HasPrefix(path, "/static/") ||
+			// 用户的公开资料，按照匹配到的路由判断，不能按前后缀，不然 /users/xxx/public 这样的路径都能绕过登录校验
+			ctx.FullPath() == "/users/:id/public" {
 			return
 		}
 
@@ -64,6 +80,29 @@ func (m *LoginJWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 			return
 		}
 
+		u, err := m.svc.FindInfoByUserId(ctx, uc.Uid)
+		if errors.Is(err, service.ErrUserNotFound) {
+			// 用户已经注销了
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, service.ErrDegraded) {
+			// 缓存不可用，数据库也扛不住了，让客户端稍后重试
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			// 查不到用户的版本号，保守起见不放行
+			log.Println(err)
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if u.TokenVersion != uc.TokenVersion {
+			// 修改过密码，之前签发的 token 都失效了
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
 		// 剩余 20 分钟过期的时候刷新 Token
 		if expireTime.Sub(time.Now()) < time.Minute*20 {
 			uc.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute * 60))
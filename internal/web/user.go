@@ -7,9 +7,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
+	"io"
 	"mini-ebook/internal/domain"
 	"mini-ebook/internal/service"
+	"mini-ebook/pkg/phone"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +21,12 @@ const (
 	emailRegexPattern    = `^\w+([-+.]\w+)*@\w+([-.]\w+)*\.\w+([-.]\w+)*$`
 	passwordRegexPattern = `^(?=.*[A-Za-z])(?=.*\d)(?=.*[$@$!%*#?&])[A-Za-z\d$@$!%*#?&]{8,72}$`
 	bizLogin             = "login"
+	bizBindPhone         = "bind_phone"
+	bizBindEmail         = "bind_email"
+	bizChangePhone       = "change_phone"
+	bizChangeEmail       = "change_email"
+	// 用户输入的手机号码不带区号的时候，默认按照中国大陆的号码处理
+	defaultPhoneRegion = "CN"
 )
 
 var validationErrors = map[string]string{
@@ -30,6 +39,8 @@ type UserClaims struct {
 	jwt.RegisteredClaims
 	Uid       int64
 	UserAgent string
+	// TokenVersion 签发时用户的 token 版本号，和用户当前的版本号不一致说明 token 已经失效
+	TokenVersion int64
 }
 
 type UserHandler struct {
@@ -37,14 +48,19 @@ type UserHandler struct {
 	passwordRegExp *regexp.Regexp
 	svc            service.UserService
 	codeSvc        service.CodeService
+	magicLinkSvc   service.MagicLinkService
+	avatarSvc      service.AvatarService
 }
 
-func NewUserHandler(svc service.UserService, codeSvc service.CodeService) *UserHandler {
+func NewUserHandler(svc service.UserService, codeSvc service.CodeService,
+	magicLinkSvc service.MagicLinkService, avatarSvc service.AvatarService) *UserHandler {
 	return &UserHandler{
 		emailRegExp:    regexp.MustCompile(emailRegexPattern, regexp.None),
 		passwordRegExp: regexp.MustCompile(passwordRegexPattern, regexp.None),
 		svc:            svc,
 		codeSvc:        codeSvc,
+		magicLinkSvc:   magicLinkSvc,
+		avatarSvc:      avatarSvc,
 	}
 }
 
@@ -55,10 +71,33 @@ func (uh *UserHandler) RegisterRoutes(server *gin.Engine) {
 	group.POST("/login", uh.LoginJWT)
 	group.POST("/edit", uh.Edit)
 	group.GET("/profile", uh.Profile)
+	group.POST("/avatar", uh.Avatar)
+	group.POST("/privacy", uh.UpdatePrivacy)
+	// 公开资料，不需要登录
+	group.GET("/:id/public", uh.PublicProfile)
+	group.POST("/password/change", uh.ChangePassword)
+	group.GET("/export", uh.Export)
+	group.POST("/delete", uh.Delete)
 
 	// 手机验证码登录相关
 	group.POST("/login_sms/code/send", uh.SendSMSLoginCode)
 	group.POST("/login_sms", uh.LoginSMS)
+
+	// 邮件链接登录相关
+	group.POST("/login_email/link/send", uh.SendEmailLoginLink)
+	group.POST("/login_email", uh.LoginEmail)
+
+	// 绑定手机号、邮箱相关
+	group.POST("/bind/phone/code/send", uh.SendBindPhoneCode)
+	group.POST("/bind/phone", uh.BindPhone)
+	group.POST("/bind/email/code/send", uh.SendBindEmailCode)
+	group.POST("/bind/email", uh.BindEmail)
+
+	// 换绑手机号、邮箱相关，验证码发送到新的手机号、邮箱上
+	group.POST("/phone/change/code/send", uh.SendChangePhoneCode)
+	group.POST("/phone/change", uh.ChangePhone)
+	group.POST("/email/change/code/send", uh.SendChangeEmailCode)
+	group.POST("/email/change", uh.ChangeEmail)
 }
 
 func (uh *UserHandler) Signup(ctx *gin.Context) {
@@ -122,22 +161,29 @@ func (uh *UserHandler) LoginJWT(ctx *gin.Context) {
 		return
 	}
 
-	u, err := uh.svc.Login(ctx, req.Email, req.Password)
+	u, err := uh.svc.Login(ctx, req.Email, req.Password, ctx.ClientIP())
 	switch {
 	case err == nil:
-		uh.setJWTToken(ctx, u.Id)
+		uh.setJWTToken(ctx, u.Id, u.TokenVersion)
 		ctx.String(http.StatusOK, "登录成功")
 	case errors.Is(err, service.ErrInvalidUserOrPassword):
 		ctx.String(http.StatusOK, "用户不存在或是密码不正确")
+	case errors.Is(err, service.ErrLoginLocked):
+		ctx.String(http.StatusOK, "登录失败次数过多，账号已被临时锁定，请稍后再试")
+	case errors.Is(err, service.ErrLoginIPLocked):
+		ctx.String(http.StatusOK, "当前网络登录失败次数过多，请稍后再试")
+	case errors.Is(err, service.ErrLoginTooFrequent):
+		ctx.String(http.StatusOK, "登录失败次数较多，请稍后再试")
 	default:
 		ctx.String(http.StatusOK, "系统错误")
 	}
 }
 
-func (uh *UserHandler) setJWTToken(ctx *gin.Context, uid int64) {
+func (uh *UserHandler) setJWTToken(ctx *gin.Context, uid int64, tokenVersion int64) {
 	uc := UserClaims{
-		Uid:       uid,
-		UserAgent: ctx.GetHeader("User-Agent"),
+		Uid:          uid,
+		UserAgent:    ctx.GetHeader("User-Agent"),
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 60)), // 60 分钟过期
 		},
@@ -150,11 +196,53 @@ func (uh *UserHandler) setJWTToken(ctx *gin.Context, uid int64) {
 	ctx.Header("x-jwt-token", tokenStr)
 }
 
+func (uh *UserHandler) ChangePassword(ctx *gin.Context) {
+	type Req struct {
+		OldPassword     string `json:"oldPassword"`
+		NewPassword     string `json:"newPassword"`
+		ConfirmPassword string `json:"confirmPassword"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+
+	if req.ConfirmPassword != req.NewPassword {
+		ctx.String(http.StatusOK, "两次密码不匹配")
+		return
+	}
+	isPassword, err := uh.passwordRegExp.MatchString(req.NewPassword)
+	if err != nil {
+		ctx.String(http.StatusOK, "系统错误")
+		return
+	}
+	if !isPassword {
+		ctx.String(http.StatusOK, "密码必须包含字母、数字、特殊字符，并且不少于八位")
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	u, err := uh.svc.ChangePassword(ctx, us.Uid, req.OldPassword, req.NewPassword)
+	switch {
+	case err == nil:
+		// 其它设备上的 token 都失效了，当前设备换一个新的 token 继续用
+		uh.setJWTToken(ctx, u.Id, u.TokenVersion)
+		ctx.String(http.StatusOK, "密码修改成功")
+	case errors.Is(err, service.ErrInvalidUserOrPassword):
+		ctx.String(http.StatusOK, "原密码不正确")
+	default:
+		ctx.String(http.StatusOK, "系统错误")
+	}
+}
+
+// Edit 修改资料，要带上 Profile 返回的版本号，修改成功之后在 x-profile-version 头部返回新的版本号给下一次保存用
 func (uh *UserHandler) Edit(ctx *gin.Context) {
 	type EditReq struct {
 		AboutMe  string `json:"aboutMe" validate:"max=200"`
 		Birthday string `json:"birthday"`
 		Nickname string `json:"nickname" validate:"max=20"`
+		// Version 为 nil 说明客户端还没有升级，先按照最后写入的生效处理
+		Version *int64 `json:"version"`
 	}
 	var req EditReq
 	if err := ctx.Bind(&req); err != nil {
@@ -180,40 +268,295 @@ func (uh *UserHandler) Edit(ctx *gin.Context) {
 		return
 	}
 
+	version := service.AnyUserVersion
+	if req.Version != nil {
+		version = *req.Version
+	}
 	us := ctx.MustGet("user").(UserClaims)
 	err = uh.svc.UpdateUserInfo(ctx, domain.User{
 		Id:       us.Uid,
 		Nickname: req.Nickname,
 		Birthday: t,
 		AboutMe:  req.AboutMe,
+		Version:  version,
 	})
+	if errors.Is(err, service.ErrVersionConflict) {
+		// 自动保存的时候不要直接覆盖，前端重新拉一次 Profile，让用户决定保留哪一份
+		ctx.String(http.StatusConflict, "资料已经在其他设备上修改过了，请刷新之后再编辑")
+		return
+	}
 	if err != nil {
 		ctx.String(http.StatusOK, "更新失败")
 		return
 	}
 
+	if req.Version != nil {
+		ctx.Header("x-profile-version", strconv.FormatInt(*req.Version+1, 10))
+	}
 	ctx.String(http.StatusOK, "更新成功")
 }
 
 func (uh *UserHandler) Profile(ctx *gin.Context) {
 	us := ctx.MustGet("user").(UserClaims)
 	u, err := uh.svc.FindInfoByUserId(ctx, us.Uid)
+	if errors.Is(err, service.ErrDegraded) {
+		ctx.String(http.StatusOK, "系统繁忙，请稍后再试")
+		return
+	}
 	if err != nil {
 		ctx.String(http.StatusOK, "系统错误")
 		return
 	}
 
 	type User struct {
-		Nickname string `json:"nickname"`
-		Email    string `json:"email"`
-		AboutMe  string `json:"aboutMe"`
-		Birthday string `json:"birthday"`
+		Nickname string  `json:"nickname"`
+		Email    string  `json:"email"`
+		AboutMe  string  `json:"aboutMe"`
+		Birthday string  `json:"birthday"`
+		Avatar   string  `json:"avatar"`
+		Privacy  Privacy `json:"privacy"`
+		// Version 修改资料的时候原样带回来
+		Version int64 `json:"version"`
 	}
 	ctx.JSON(http.StatusOK, User{
 		Nickname: u.Nickname,
 		Email:    u.Email,
 		AboutMe:  u.AboutMe,
-		Birthday: u.Birthday.Format(time.DateOnly),
+		Birthday: formatBirthday(u.Birthday),
+		Avatar:   u.Avatar,
+		Privacy:  newPrivacy(u.Privacy),
+		Version:  u.Version,
+	})
+}
+
+// Privacy 隐私设置，Profile、UpdatePrivacy 和 Export 共用
+type Privacy struct {
+	HideBirthday   bool `json:"hideBirthday"`
+	HideAboutMe    bool `json:"hideAboutMe"`
+	HideFromSearch bool `json:"hideFromSearch"`
+}
+
+func newPrivacy(p domain.PrivacySettings) Privacy {
+	return Privacy{
+		HideBirthday:   p.HideBirthday,
+		HideAboutMe:    p.HideAboutMe,
+		HideFromSearch: p.HideFromSearch,
+	}
+}
+
+func (uh *UserHandler) UpdatePrivacy(ctx *gin.Context) {
+	var req Privacy
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	us := ctx.MustGet("user").(UserClaims)
+	err := uh.svc.UpdatePrivacy(ctx, us.Uid, domain.PrivacySettings{
+		HideBirthday:   req.HideBirthday,
+		HideAboutMe:    req.HideAboutMe,
+		HideFromSearch: req.HideFromSearch,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, Result{
+		Msg: "更新成功",
+	})
+}
+
+// PublicProfile 其他人可以看到的资料，不包含邮箱、手机号
+func (uh *UserHandler) PublicProfile(ctx *gin.Context) {
+	uid, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "用户不存在",
+		})
+		return
+	}
+	u, err := uh.svc.FindPublicProfile(ctx, uid)
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "用户不存在",
+		})
+		return
+	case errors.Is(err, service.ErrDegraded):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统繁忙，请稍后再试",
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+
+	type PublicUser struct {
+		Id       int64  `json:"id,string"`
+		Nickname string `json:"nickname"`
+		Avatar   string `json:"avatar"`
+		// 隐藏了的字段不返回
+		Birthday string `json:"birthday,omitempty"`
+		AboutMe  string `json:"aboutMe,omitempty"`
+	}
+	pu := PublicUser{
+		Id:       u.Id,
+		Nickname: u.Nickname,
+		Avatar:   u.Avatar,
+		AboutMe:  u.AboutMe,
+	}
+	pu.Birthday = formatBirthday(u.Birthday)
+	ctx.JSON(http.StatusOK, Result{
+		Data: pu,
+	})
+}
+
+// formatBirthday 没有填生日的时候返回空字符串
+func formatBirthday(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateOnly)
+}
+
+// Avatar 上传头像，表单字段是 avatar
+func (uh *UserHandler) Avatar(ctx *gin.Context) {
+	// 多留一点给表单的其他部分，超过这个大小直接读取失败
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, service.MaxAvatarSize+1<<20)
+	fh, err := ctx.FormFile("avatar")
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "请选择不超过 5MB 的头像",
+		})
+		return
+	}
+	if fh.Size > service.MaxAvatarSize {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "头像不能超过 5MB",
+		})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	url, err := uh.avatarSvc.Upload(ctx, us.Uid, data)
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, Result{
+			Msg:  "上传成功",
+			Data: url,
+		})
+	case errors.Is(err, service.ErrAvatarTooLarge):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "头像太大了，请换一张小一点的图片",
+		})
+	case errors.Is(err, service.ErrAvatarUnsupportedType):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "只支持 JPEG、PNG、GIF 格式的图片",
+		})
+	default:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+	}
+}
+
+// Export 导出我们保存的所有关于当前用户的数据
+func (uh *UserHandler) Export(ctx *gin.Context) {
+	us := ctx.MustGet("user").(UserClaims)
+	u, err := uh.svc.FindAccountByUserId(ctx, us.Uid)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+
+	type Account struct {
+		Id    int64  `json:"id,string"`
+		Email string `json:"email"`
+		Phone string `json:"phone"`
+		// HasPassword 密码只保存了哈希，导出的时候只告诉用户有没有设置过密码
+		HasPassword bool   `json:"hasPassword"`
+		Ctime       string `json:"ctime"`
+		Utime       string `json:"utime"`
+	}
+	type Profile struct {
+		Nickname string `json:"nickname"`
+		Birthday string `json:"birthday"`
+		AboutMe  string `json:"aboutMe"`
+		Avatar   string `json:"avatar"`
+	}
+	type Archive struct {
+		ExportedAt string  `json:"exportedAt"`
+		Account    Account `json:"account"`
+		Profile    Profile `json:"profile"`
+		Privacy    Privacy `json:"privacy"`
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="mini-book-user-%d.json"`, u.Id))
+	ctx.JSON(http.StatusOK, Archive{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Account: Account{
+			Id:          u.Id,
+			Email:       u.Email,
+			Phone:       u.Phone,
+			HasPassword: u.Password != "",
+			Ctime:       u.Ctime.Format(time.RFC3339),
+			Utime:       u.Utime.Format(time.RFC3339),
+		},
+		Profile: Profile{
+			Nickname: u.Nickname,
+			Birthday: formatBirthday(u.Birthday),
+			AboutMe:  u.AboutMe,
+			Avatar:   u.Avatar,
+		},
+		Privacy: newPrivacy(u.Privacy),
+	})
+}
+
+// Delete 注销当前用户，注销之后所有的 token 都会失效
+func (uh *UserHandler) Delete(ctx *gin.Context) {
+	us := ctx.MustGet("user").(UserClaims)
+	err := uh.svc.DeleteAccount(ctx, us.Uid)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, Result{
+		Msg: "注销成功",
 	})
 }
 
@@ -227,23 +570,29 @@ func (uh *UserHandler) LoginSMS(ctx *gin.Context) {
 		return
 	}
 
-	ok, err := uh.codeSvc.Verify(ctx, bizLogin, req.Phone, req.Code)
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
 	if err != nil {
 		ctx.JSON(http.StatusOK, Result{
-			Code: 5,
-			Msg:  "系统异常",
+			Code: 4,
+			Msg:  "手机号码格式不正确",
 		})
 		return
 	}
-	if !ok {
+
+	res, err := uh.codeSvc.Verify(ctx, bizLogin, number, req.Code)
+	if err != nil {
 		ctx.JSON(http.StatusOK, Result{
-			Code: 4,
-			Msg:  "验证码不对，请重新输入",
+			Code: 5,
+			Msg:  "系统异常",
 		})
 		return
 	}
+	if !res.Ok() {
+		ctx.JSON(http.StatusOK, uh.codeVerifyFailedResult(res))
+		return
+	}
 
-	u, err := uh.svc.FindOrCreate(ctx, req.Phone)
+	u, err := uh.svc.FindOrCreate(ctx, number)
 	if err != nil {
 		ctx.JSON(http.StatusOK, Result{
 			Code: 5,
@@ -251,12 +600,40 @@ func (uh *UserHandler) LoginSMS(ctx *gin.Context) {
 		})
 		return
 	}
-	uh.setJWTToken(ctx, u.Id)
+	uh.setJWTToken(ctx, u.Id, u.TokenVersion)
 	ctx.JSON(http.StatusOK, Result{
 		Msg: "登录成功",
 	})
 }
 
+// codeVerifyFailedResult 把验证码校验失败的原因转成对应的 Result
+func (uh *UserHandler) codeVerifyFailedResult(res domain.CodeVerifyResult) Result {
+	switch res.Status {
+	case domain.CodeVerifyExpired:
+		return Result{
+			Code: 6,
+			Msg:  "验证码已过期，请重新获取",
+		}
+	case domain.CodeVerifyExhausted:
+		return Result{
+			Code: 7,
+			Msg:  "验证次数过多，请重新获取验证码",
+		}
+	case domain.CodeVerifyUsed:
+		// 和过期一样，要重新获取验证码
+		return Result{
+			Code: 6,
+			Msg:  "验证码已经使用过了，请重新获取",
+		}
+	default:
+		return Result{
+			Code: 4,
+			Msg:  fmt.Sprintf("验证码不对，请重新输入，还可以尝试 %d 次", res.Remaining),
+			Data: res.Remaining,
+		}
+	}
+}
+
 func (uh *UserHandler) SendSMSLoginCode(ctx *gin.Context) {
 	type Req struct {
 		Phone string `json:"phone"`
@@ -272,7 +649,15 @@ func (uh *UserHandler) SendSMSLoginCode(ctx *gin.Context) {
 		})
 		return
 	}
-	err := uh.codeSvc.Send(ctx, bizLogin, req.Phone)
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "手机号码格式不正确",
+		})
+		return
+	}
+	err = uh.codeSvc.Send(ctx, bizLogin, number)
 	switch {
 	case err == nil:
 		ctx.JSON(http.StatusOK, Result{
@@ -291,3 +676,389 @@ func (uh *UserHandler) SendSMSLoginCode(ctx *gin.Context) {
 		// 补充日志
 	}
 }
+
+func (uh *UserHandler) SendEmailLoginLink(ctx *gin.Context) {
+	type Req struct {
+		Email string `json:"email"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	isEmail, err := uh.emailRegExp.MatchString(req.Email)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+		return
+	}
+	if !isEmail {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "非法邮箱格式",
+		})
+		return
+	}
+
+	err = uh.magicLinkSvc.Send(ctx, req.Email)
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, Result{
+			Msg: "发送成功",
+		})
+	case errors.Is(err, service.ErrMagicLinkSendTooMany):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "邮件发送太频繁，请稍后再试",
+		})
+	default:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统错误",
+		})
+	}
+}
+
+func (uh *UserHandler) LoginEmail(ctx *gin.Context) {
+	type Req struct {
+		Token string `json:"token"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+
+	email, err := uh.magicLinkSvc.Verify(ctx, req.Token)
+	switch {
+	case errors.Is(err, service.ErrInvalidMagicLink):
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "登录链接不合法或者已经失效，请重新获取",
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统异常",
+		})
+		return
+	}
+
+	u, err := uh.svc.FindOrCreateByEmail(ctx, email)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统异常",
+		})
+		return
+	}
+	uh.setJWTToken(ctx, u.Id, u.TokenVersion)
+	ctx.JSON(http.StatusOK, Result{
+		Msg: "登录成功",
+	})
+}
+
+func (uh *UserHandler) SendBindPhoneCode(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "手机号码格式不正确",
+		})
+		return
+	}
+	err = uh.codeSvc.Send(ctx, bizBindPhone, number)
+	ctx.JSON(http.StatusOK, uh.codeSendResult(err))
+}
+
+func (uh *UserHandler) BindPhone(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+		Code  string `json:"code"`
+		// Merge 手机号已经属于另一个账号的时候，是否把那个账号合并到当前账号
+		Merge bool `json:"merge"`
+		// MergeToken 上一次绑定返回 Code 8 的时候拿到的，带上它就不用再填验证码了
+		MergeToken string `json:"mergeToken"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "手机号码格式不正确",
+		})
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	if !uh.verifyBind(ctx, us.Uid, bizBindPhone, number, req.Code, req.MergeToken) {
+		return
+	}
+	err = uh.svc.BindPhone(ctx, us.Uid, number, req.Merge)
+	ctx.JSON(http.StatusOK, uh.bindResult(err, us.Uid, bizBindPhone, number))
+}
+
+func (uh *UserHandler) SendBindEmailCode(ctx *gin.Context) {
+	type Req struct {
+		Email string `json:"email"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	isEmail, err := uh.emailRegExp.MatchString(req.Email)
+	if err != nil || !isEmail {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "非法邮箱格式",
+		})
+		return
+	}
+	err = uh.codeSvc.SendEmail(ctx, bizBindEmail, req.Email)
+	ctx.JSON(http.StatusOK, uh.codeSendResult(err))
+}
+
+func (uh *UserHandler) BindEmail(ctx *gin.Context) {
+	type Req struct {
+		Email      string `json:"email"`
+		Code       string `json:"code"`
+		Merge      bool   `json:"merge"`
+		MergeToken string `json:"mergeToken"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	if !uh.verifyBind(ctx, us.Uid, bizBindEmail, req.Email, req.Code, req.MergeToken) {
+		return
+	}
+	err := uh.svc.BindEmail(ctx, us.Uid, req.Email, req.Merge)
+	ctx.JSON(http.StatusOK, uh.bindResult(err, us.Uid, bizBindEmail, req.Email))
+}
+
+// verifyBind 校验绑定用的验证码，带着合并凭证的时候校验凭证，不通过的时候已经写好了响应
+func (uh *UserHandler) verifyBind(ctx *gin.Context, uid int64, biz string, contact string, code string, mergeToken string) bool {
+	if mergeToken != "" {
+		if uh.checkMergeToken(mergeToken, uid, biz, contact) {
+			return true
+		}
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "合并凭证无效或者已经过期，请重新获取验证码",
+		})
+		return false
+	}
+	res, err := uh.codeSvc.Verify(ctx, biz, contact, code)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统异常",
+		})
+		return false
+	}
+	if !res.Ok() {
+		ctx.JSON(http.StatusOK, uh.codeVerifyFailedResult(res))
+		return false
+	}
+	return true
+}
+
+// codeSendResult 把发送验证码的结果转成对应的 Result
+func (uh *UserHandler) codeSendResult(err error) Result {
+	switch {
+	case err == nil:
+		return Result{
+			Msg: "发送成功",
+		}
+	case errors.Is(err, service.ErrorCodeSendTooMany):
+		return Result{
+			Code: 4,
+			Msg:  "验证码发送太频繁，请稍后再试",
+		}
+	default:
+		return Result{
+			Code: 5,
+			Msg:  "系统错误",
+		}
+	}
+}
+
+// bindResult 把绑定手机号、邮箱的结果转成对应的 Result
+func (uh *UserHandler) bindResult(err error, uid int64, biz string, contact string) Result {
+	switch {
+	case err == nil:
+		return Result{
+			Msg: "绑定成功",
+		}
+	case errors.Is(err, service.ErrContactAlreadyBound):
+		return Result{
+			Code: 4,
+			Msg:  "已经绑定过了，如需更换请使用换绑功能",
+		}
+	case errors.Is(err, service.ErrContactBoundToOther):
+		// 验证码已经用掉了，前端看到这个 Code 之后，可以提示用户是否要合并账号，
+		// 然后带上 merge 和 mergeToken 重新绑定
+		token, err := uh.signMergeToken(uid, biz, contact)
+		if err != nil {
+			return Result{
+				Code: 5,
+				Msg:  "系统错误",
+			}
+		}
+		type BindConflictResp struct {
+			MergeToken string `json:"mergeToken"`
+		}
+		return Result{
+			Code: 8,
+			Msg:  "已经绑定了其他账号，可以选择合并账号",
+			Data: BindConflictResp{MergeToken: token},
+		}
+	case errors.Is(err, service.ErrMergeConflict):
+		return Result{
+			Code: 4,
+			Msg:  "两个账号的信息冲突，无法自动合并",
+		}
+	default:
+		return Result{
+			Code: 5,
+			Msg:  "系统错误",
+		}
+	}
+}
+
+func (uh *UserHandler) SendChangePhoneCode(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "手机号码格式不正确",
+		})
+		return
+	}
+	err = uh.codeSvc.Send(ctx, bizChangePhone, number)
+	ctx.JSON(http.StatusOK, uh.codeSendResult(err))
+}
+
+func (uh *UserHandler) ChangePhone(ctx *gin.Context) {
+	type Req struct {
+		Phone string `json:"phone"`
+		Code  string `json:"code"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	number, err := phone.Parse(req.Phone, defaultPhoneRegion)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "手机号码格式不正确",
+		})
+		return
+	}
+
+	res, err := uh.codeSvc.Verify(ctx, bizChangePhone, number, req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统异常",
+		})
+		return
+	}
+	if !res.Ok() {
+		ctx.JSON(http.StatusOK, uh.codeVerifyFailedResult(res))
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	err = uh.svc.ChangePhone(ctx, us.Uid, number)
+	ctx.JSON(http.StatusOK, uh.changeContactResult(err))
+}
+
+func (uh *UserHandler) SendChangeEmailCode(ctx *gin.Context) {
+	type Req struct {
+		Email string `json:"email"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+	isEmail, err := uh.emailRegExp.MatchString(req.Email)
+	if err != nil || !isEmail {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 4,
+			Msg:  "非法邮箱格式",
+		})
+		return
+	}
+	err = uh.codeSvc.SendEmail(ctx, bizChangeEmail, req.Email)
+	ctx.JSON(http.StatusOK, uh.codeSendResult(err))
+}
+
+func (uh *UserHandler) ChangeEmail(ctx *gin.Context) {
+	type Req struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	var req Req
+	if err := ctx.Bind(&req); err != nil {
+		return
+	}
+
+	res, err := uh.codeSvc.Verify(ctx, bizChangeEmail, req.Email, req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: 5,
+			Msg:  "系统异常",
+		})
+		return
+	}
+	if !res.Ok() {
+		ctx.JSON(http.StatusOK, uh.codeVerifyFailedResult(res))
+		return
+	}
+
+	us := ctx.MustGet("user").(UserClaims)
+	err = uh.svc.ChangeEmail(ctx, us.Uid, req.Email)
+	ctx.JSON(http.StatusOK, uh.changeContactResult(err))
+}
+
+// changeContactResult 把换绑手机号、邮箱的结果转成对应的 Result
+func (uh *UserHandler) changeContactResult(err error) Result {
+	switch {
+	case err == nil:
+		return Result{
+			Msg: "修改成功",
+		}
+	case errors.Is(err, service.ErrContactBoundToOther):
+		return Result{
+			Code: 4,
+			Msg:  "已经被其他账号使用了",
+		}
+	default:
+		return Result{
+			Code: 5,
+			Msg:  "系统错误",
+		}
+	}
+}
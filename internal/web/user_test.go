@@ -0,0 +1,34 @@
+package web
+
+import (
+	"mini-ebook/internal/domain"
+	"testing"
+)
+
+func TestUserHandler_CodeVerifyFailedResult(t *testing.T) {
+	testCases := []struct {
+		name     string
+		res      domain.CodeVerifyResult
+		wantCode int
+	}{
+		{name: "验证码不对", res: domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: 2}, wantCode: 4},
+		{name: "过期", res: domain.CodeVerifyResult{Status: domain.CodeVerifyExpired}, wantCode: 6},
+		{name: "已经用过", res: domain.CodeVerifyResult{Status: domain.CodeVerifyUsed}, wantCode: 6},
+		{name: "次数用完", res: domain.CodeVerifyResult{Status: domain.CodeVerifyExhausted}, wantCode: 7},
+	}
+
+	uh := &UserHandler{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := uh.codeVerifyFailedResult(tc.res)
+			if got.Code != tc.wantCode {
+				t.Fatalf("期望 Code %d，实际 %d %s", tc.wantCode, got.Code, got.Msg)
+			}
+		})
+	}
+	// 验证码不对的时候要告诉前端还剩几次
+	got := uh.codeVerifyFailedResult(domain.CodeVerifyResult{Status: domain.CodeVerifyMismatch, Remaining: 2})
+	if got.Data != 2 {
+		t.Fatalf("期望剩余次数 2，实际 %v", got.Data)
+	}
+}
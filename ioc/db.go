@@ -1,22 +1,85 @@
 package ioc
 
 import (
+	"fmt"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 	"mini-ebook/config"
-	"mini-ebook/internal/repository/dao"
 )
 
+// InitDB 只负责连接数据库，表结构由 migrate 子命令维护
+// 配置了从库的话，读操作随机走一个从库，写操作和事务走主库
 func InitDB() *gorm.DB {
-	db, err := gorm.Open(mysql.Open(config.Config.DB.DSN))
+	db := InitPrimaryDB()
+	useReplicas(db, config.Config.DB.Replicas)
+	return db
+}
+
+// InitPrimaryDB 只连主库，数据库迁移、数据修复之类的必须在主库上执行
+func InitPrimaryDB() *gorm.DB {
+	db, err := gorm.Open(openDialector(config.Config.DB.Driver, config.Config.DB.DSN))
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// InitUserShardDBs 用户分库，没有配置的时候返回 nil
+// 和 InitDB 一样，配置了从库的分库读操作走从库
+func InitUserShardDBs() []*gorm.DB {
+	res := InitUserShardPrimaryDBs()
+	replicas := config.Config.DB.ShardReplicas
+	for i := 0; i < len(res) && i < len(replicas); i++ {
+		useReplicas(res[i], replicas[i])
+	}
+	return res
+}
+
+// InitUserShardPrimaryDBs 只连分库的主库，调整分库之类的运维工具用
+func InitUserShardPrimaryDBs() []*gorm.DB {
+	var res []*gorm.DB
+	for _, dsn := range config.Config.DB.Shards {
+		res = append(res, OpenDB(dsn))
+	}
+	return res
+}
+
+// OpenDB 用配置里的数据库驱动打开 dsn，给运维工具用
+func OpenDB(dsn string) *gorm.DB {
+	db, err := gorm.Open(openDialector(config.Config.DB.Driver, dsn))
 	if err != nil {
 		panic(err)
 	}
+	return db
+}
 
-	err = dao.InitTables(db)
+// useReplicas 读操作随机走一个从库，写操作和事务走主库，replicas 为空的时候什么都不做
+func useReplicas(db *gorm.DB, replicas []string) {
+	if len(replicas) == 0 {
+		return
+	}
+	dialectors := make([]gorm.Dialector, 0, len(replicas))
+	for _, dsn := range replicas {
+		dialectors = append(dialectors, openDialector(config.Config.DB.Driver, dsn))
+	}
+	err := db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   dbresolver.RandomPolicy{},
+	}))
 	if err != nil {
 		panic(err)
 	}
+}
 
-	return db
+func openDialector(driver string, dsn string) gorm.Dialector {
+	switch driver {
+	case "", "mysql":
+		return mysql.Open(dsn)
+	case "sqlite":
+		return sqlite.Open(dsn)
+	default:
+		panic(fmt.Errorf("不支持的数据库驱动 %s", driver))
+	}
 }
@@ -0,0 +1,34 @@
+package ioc
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/config"
+	"mini-ebook/internal/repository/dao"
+	"mini-ebook/pkg/snowflake"
+	"time"
+)
+
+// snowflakeLeaseTTL 实例挂了之后，它的 worker id 最多过这么久可以被别的实例用
+const snowflakeLeaseTTL = 30 * time.Second
+
+// InitIdGenerator 单实例部署直接用配置里的 worker id，多副本部署从 Redis 租一个，免得手动分配
+func InitIdGenerator(cmd redis.Cmdable) dao.IdGenerator {
+	cfg := config.Config.Snowflake
+	if !cfg.WorkerIdFromRedis {
+		g, err := snowflake.New(cfg.WorkerId)
+		if err != nil {
+			panic(err)
+		}
+		return g
+	}
+	lease, err := snowflake.AcquireLease(context.Background(), cmd, "snowflake:worker", snowflakeLeaseTTL)
+	if err != nil {
+		panic(err)
+	}
+	g, err := snowflake.NewWithLease(lease)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
@@ -0,0 +1,19 @@
+package ioc
+
+import (
+	"mini-ebook/config"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/service"
+	"mini-ebook/internal/service/mail"
+)
+
+// magicLinkMinKeyLen HMAC-SHA256 的密钥至少要和摘要一样长
+const magicLinkMinKeyLen = 32
+
+func InitMagicLinkService(repo repository.MagicLinkRepository, mailSvc mail.Service) service.MagicLinkService {
+	key := config.Config.MagicLink.Key
+	if len(key) < magicLinkMinKeyLen {
+		panic("登录链接的签名密钥 MagicLink.Key 没有配置或者太短")
+	}
+	return service.NewMagicLinkService(repo, mailSvc, []byte(key))
+}
@@ -0,0 +1,10 @@
+package ioc
+
+import (
+	"mini-ebook/internal/service/mail"
+	"mini-ebook/internal/service/mail/localMail"
+)
+
+func InitMailService() mail.Service {
+	return localMail.NewService()
+}
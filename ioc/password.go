@@ -0,0 +1,14 @@
+package ioc
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"mini-ebook/internal/service/password"
+)
+
+// InitPasswordHasher 新密码用 argon2id，历史上用 bcrypt 生成的哈希在登录的时候自动升级
+func InitPasswordHasher() password.Hasher {
+	return password.NewMultiHasher(
+		password.NewArgon2idHasher(password.DefaultArgon2idParams),
+		password.NewBcryptHasher(bcrypt.DefaultCost),
+	)
+}
@@ -1,13 +1,66 @@
 package ioc
 
 import (
+	"crypto/tls"
+	"fmt"
 	"github.com/redis/go-redis/v9"
 	"mini-ebook/config"
+	"mini-ebook/pkg/redisx"
 )
 
-func InitRedis() redis.Cmdable {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: config.Config.Redis.Addr,
-	})
-	return redisClient
+// InitRedis 根据配置创建单机、哨兵或者集群的客户端
+// 返回 UniversalClient，除了普通命令（redis.Cmdable），用户缓存还要用它来订阅失效消息
+func InitRedis() redis.UniversalClient {
+	cfg := config.Config.Redis
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	var client redis.UniversalClient
+	switch cfg.Mode {
+	case "", "standalone":
+		client = redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			PoolSize:     cfg.PoolSize,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		})
+	case "sentinel":
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.MasterName,
+			SentinelAddrs: cfg.Addrs,
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			DB:            cfg.DB,
+			PoolSize:      cfg.PoolSize,
+			DialTimeout:   cfg.DialTimeout,
+			ReadTimeout:   cfg.ReadTimeout,
+			WriteTimeout:  cfg.WriteTimeout,
+			TLSConfig:     tlsConfig,
+		})
+	case "cluster":
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			PoolSize:     cfg.PoolSize,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		})
+	default:
+		panic(fmt.Errorf("不支持的 Redis 模式 %s", cfg.Mode))
+	}
+
+	if cfg.KeyPrefix != "" {
+		client.AddHook(redisx.NewKeyPrefixHook(cfg.KeyPrefix))
+	}
+	return client
 }
@@ -1,6 +1,7 @@
 package ioc
 
 import (
+	"mini-ebook/config"
 	"mini-ebook/internal/service/sms"
 	"mini-ebook/internal/service/sms/localSms"
 )
@@ -8,3 +9,10 @@ import (
 func InitSMSService() sms.Service {
 	return localSms.NewServcie()
 }
+
+func InitSMSTemplates() sms.Templates {
+	return sms.Templates{
+		Code:         config.Config.SMS.CodeTplId,
+		PhoneChanged: config.Config.SMS.PhoneChangedTplId,
+	}
+}
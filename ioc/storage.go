@@ -0,0 +1,16 @@
+package ioc
+
+import (
+	"mini-ebook/internal/service/storage"
+	"mini-ebook/internal/service/storage/localStorage"
+)
+
+const (
+	// localStorageDir 本地对象存储的目录，InitWebServer 会把它映射到 localStorageURL 上
+	localStorageDir = "./data/static"
+	localStorageURL = "/static"
+)
+
+func InitObjectStorage() storage.Service {
+	return localStorage.NewService(localStorageDir, localStorageURL)
+}
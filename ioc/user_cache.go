@@ -0,0 +1,22 @@
+package ioc
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/internal/repository/cache"
+	"time"
+)
+
+const (
+	// localUserCacheSize 本地最多缓存的用户数量
+	localUserCacheSize = 10000
+	// localUserCacheTTL 本地缓存的过期时间，失效消息丢了的话最多读到这么久的旧数据
+	localUserCacheTTL = 10 * time.Second
+)
+
+// InitUserCache 本地 LRU + Redis 的两级缓存，返回的 func 停止订阅失效消息
+func InitUserCache(client redis.UniversalClient) (cache.UserCache, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local := cache.NewLocalUserCache(localUserCacheSize, localUserCacheTTL)
+	return cache.NewTwoLevelUserCache(ctx, local, cache.NewUserCache(client), client), cancel
+}
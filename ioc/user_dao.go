@@ -0,0 +1,15 @@
+package ioc
+
+import (
+	"gorm.io/gorm"
+	"mini-ebook/internal/repository/dao"
+)
+
+// InitUserDAO 配置了分库就按用户 id 路由到各个库，否则所有用户都在主库
+func InitUserDAO(db *gorm.DB, ids dao.IdGenerator) dao.UserDAO {
+	shards := InitUserShardDBs()
+	if len(shards) == 0 {
+		return dao.NewUserDao(db, ids)
+	}
+	return dao.NewShardedUserDAO(db, shards, ids)
+}
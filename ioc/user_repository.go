@@ -0,0 +1,35 @@
+package ioc
+
+import (
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/config"
+	"mini-ebook/internal/repository"
+	"mini-ebook/internal/repository/cache"
+	"mini-ebook/internal/repository/dao"
+)
+
+const (
+	// userBloomBits 按一千万用户、1% 的误判率估算
+	userBloomBits   = 1 << 27
+	userBloomHashes = 7
+	// userDegradeConcurrency Redis 挂了之后，单个实例最多同时有多少个请求直接查数据库
+	userDegradeConcurrency = 50
+)
+
+func InitUserBloomFilter(cmd redis.Cmdable) cache.UserBloomFilter {
+	return cache.NewUserBloomFilter(cmd, userBloomBits, userBloomHashes)
+}
+
+func InitUserRepository(d dao.UserDAO, c cache.UserCache, cmd redis.Cmdable) repository.UserRepository {
+	opts := repository.CachedUserRepositoryOptions{
+		DegradeConcurrency: userDegradeConcurrency,
+		DoubleDeleteDelay:  config.Config.UserCache.DoubleDeleteDelay,
+	}
+	if config.Config.UserCache.BloomFilter {
+		opts.Bloom = InitUserBloomFilter(cmd)
+	}
+	if config.Config.UserCache.RebuildLock {
+		opts.RebuildLock = cache.NewUserRebuildLock(cmd)
+	}
+	return repository.NewCachedUserRepositoryWithOptions(d, c, opts)
+}
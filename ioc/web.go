@@ -4,6 +4,8 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
+	"mini-ebook/config"
+	"mini-ebook/internal/service"
 	"mini-ebook/internal/web"
 	"mini-ebook/internal/web/middleware"
 	"mini-ebook/pkg/ginx/middleware/ratelimit"
@@ -11,20 +13,27 @@ import (
 	"time"
 )
 
-func InitWebServer(mdls []gin.HandlerFunc, userHdl *web.UserHandler) *gin.Engine {
+func InitWebServer(mdls []gin.HandlerFunc, userHdl *web.UserHandler, adminUserHdl *web.AdminUserHandler) *gin.Engine {
 	server := gin.Default()
+	// gin 默认相信所有代理，客户端随便伪造一个 X-Forwarded-For 就能绕过按 IP 的登录限制和限流
+	if err := server.SetTrustedProxies(config.Config.Web.TrustedProxies); err != nil {
+		panic(err)
+	}
 	server.Use(mdls...)
+	// 本地对象存储里的文件，比如头像
+	server.Static(localStorageURL, localStorageDir)
 	userHdl.RegisterRoutes(server)
+	adminUserHdl.RegisterRoutes(server)
 	return server
 }
 
-func InitGinMiddlewares(redisClient redis.Cmdable) []gin.HandlerFunc {
+func InitGinMiddlewares(redisClient redis.Cmdable, userSvc service.UserService) []gin.HandlerFunc {
 	return []gin.HandlerFunc{
 		// cors 跨域中间件
 		cors.New(cors.Config{
 			AllowCredentials: true,
 			AllowHeaders:     []string{"Authorization", "Content-Type"},
-			ExposeHeaders:    []string{"x-jwt-token"}, // 允许前端访问服务响应的头部
+			ExposeHeaders:    []string{"x-jwt-token", "x-profile-version"}, // 允许前端访问服务响应的头部
 			AllowOriginFunc: func(origin string) bool {
 				if strings.HasPrefix(origin, "http://localhost") {
 					return true
@@ -38,6 +47,6 @@ func InitGinMiddlewares(redisClient redis.Cmdable) []gin.HandlerFunc {
 		ratelimit.NewBuilder(redisClient, time.Second, 100).Build(),
 
 		// JWT 验权
-		(&middleware.LoginJWTMiddlewareBuilder{}).CheckLogin(),
+		middleware.NewLoginJWTMiddlewareBuilder(userSvc).CheckLogin(),
 	}
 }
@@ -1,8 +1,25 @@
 package main
 
+import (
+	"context"
+	"os"
+)
+
 func main() {
-	server := InitWebServer()
-	err := server.Run(":8080")
+	// 表结构的变更通过 migrate 子命令执行，k8s 里在发布之前用单独的 Job 跑 migrate up
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	app, cleanup := InitApp()
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.purgeUserJob.Start(ctx)
+
+	err := app.server.Run(":8080")
 	if err != nil {
 		panic(err)
 	}
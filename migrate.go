@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mini-ebook/config"
+	"mini-ebook/internal/repository/dao"
+	"mini-ebook/ioc"
+	"mini-ebook/pkg/migrator"
+	"os"
+	"time"
+)
+
+const migrateUsage = "用法：mini-book migrate up|down|status"
+
+// runMigrate 数据库迁移子命令，配置了用户分库的话主库和每个分库都要执行
+// up 执行所有还没执行的迁移，down 回滚最后一个迁移，status 查看执行情况
+func runMigrate(args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+	// 主库也可以是其中一个分库，同一个库只迁移一次，不然 down 会连着回滚两次
+	dsns := []string{config.Config.DB.DSN}
+	names := map[string]string{config.Config.DB.DSN: "主库"}
+	for i, dsn := range config.Config.DB.Shards {
+		if _, ok := names[dsn]; ok {
+			names[dsn] += fmt.Sprintf("（分库 %d）", i)
+			continue
+		}
+		dsns = append(dsns, dsn)
+		names[dsn] = fmt.Sprintf("分库 %d", i)
+	}
+	for _, dsn := range dsns {
+		log.Printf("%s：", names[dsn])
+		m, err := dao.NewMigrator(ioc.OpenDB(dsn))
+		if err != nil {
+			log.Fatalf("读取迁移文件失败：%v", err)
+		}
+		migrate(m, args[0])
+	}
+}
+
+func migrate(m *migrator.Migrator, cmd string) {
+	ctx := context.Background()
+	switch cmd {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mg := range applied {
+			log.Printf("已执行 %04d_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			log.Fatalf("迁移失败：%v", err)
+		}
+		log.Printf("迁移完成，这次执行了 %d 个", len(applied))
+	case "down":
+		mg, err := m.Down(ctx)
+		if errors.Is(err, migrator.ErrNoApplied) {
+			log.Println("没有可以回滚的迁移")
+			return
+		}
+		if err != nil {
+			log.Fatalf("回滚失败：%v", err)
+		}
+		log.Printf("已回滚 %04d_%s", mg.Version, mg.Name)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("查询迁移状态失败：%v", err)
+		}
+		for _, s := range statuses {
+			appliedAt := "未执行"
+			if s.Applied() {
+				appliedAt = s.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(os.Stdout, "%04d_%-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
@@ -58,6 +58,7 @@ func (b *Builder) Build() gin.HandlerFunc {
 }
 
 func (b *Builder) limit(ctx *gin.Context) (bool, error) {
+	// 脚本只操作这一个 key，Redis Cluster 下也不会跨 slot
 	key := fmt.Sprintf("%s:%s", b.prefix, ctx.ClientIP())
 
 	return b.cmd.Eval(ctx, luaScript, []string{key},
@@ -0,0 +1,68 @@
+package imagex
+
+import (
+	"image"
+	"image/color"
+)
+
+// CropSquare 从中间裁剪出一个正方形
+func CropSquare(src image.Image) image.Image {
+	b := src.Bounds()
+	size := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-size)/2
+	y0 := b.Min.Y + (b.Dy()-size)/2
+	rect := image.Rect(x0, y0, x0+size, y0+size)
+
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	if si, ok := src.(subImager); ok {
+		return si.SubImage(rect)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dst.Set(x, y, src.At(x0+x, y0+y))
+		}
+	}
+	return dst
+}
+
+// Resize 缩放到 width * height，缩小的时候对覆盖到的源像素取平均值，放大的时候取最近的像素
+// 头像这种场景基本都是缩小，没必要为了效果引入专门的图片处理库
+func Resize(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 || width <= 0 || height <= 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		sy0 := b.Min.Y + y*sh/height
+		sy1 := max(b.Min.Y+(y+1)*sh/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := b.Min.X + x*sw/width
+			sx1 := max(b.Min.X+(x+1)*sw/width, sx0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,34 @@
+package imagex
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCropAndResize(t *testing.T) {
+	// 左半边黑色，右半边白色的 400 * 200 图片
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{A: 255}
+			if x >= 200 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	square := CropSquare(src)
+	if b := square.Bounds(); b.Dx() != 200 || b.Dy() != 200 || b.Min.X != 100 {
+		t.Fatalf("裁剪的区域不对 %v", b)
+	}
+
+	dst := Resize(square, 2, 2)
+	if dst.Bounds().Dx() != 2 || dst.Bounds().Dy() != 2 {
+		t.Fatalf("缩放之后的尺寸不对 %v", dst.Bounds())
+	}
+	if left, right := dst.RGBAAt(0, 0), dst.RGBAAt(1, 0); left.R != 0 || right.R != 255 {
+		t.Fatalf("缩放之后的颜色不对 %v %v", left, right)
+	}
+}
@@ -0,0 +1,284 @@
+// Package migrator 按版本号顺序执行 SQL 迁移文件，并在 schema_migrations 表里记录执行过的版本
+//
+// 迁移文件的命名格式是 版本号_名字.up.sql 和 版本号_名字.down.sql，例如 0001_create_users.up.sql。
+// 一个文件里可以有多条语句，每条语句以行尾的分号结束。
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoApplied = errors.New("没有可以回滚的迁移")
+	ErrLocked    = errors.New("其他实例正在执行迁移")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status 迁移的执行情况，没有执行过的 AppliedAt 是零值
+type Status struct {
+	Migration
+	AppliedAt time.Time
+}
+
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	// lockName 多个实例同时启动的时候，只有拿到锁的那个执行迁移
+	lockName    string
+	lockTimeout time.Duration
+}
+
+// New 从 fsys 的根目录读取所有迁移文件
+func New(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:          db,
+		migrations:  migrations,
+		lockName:    "schema_migrations",
+		lockTimeout: time.Minute,
+	}, nil
+}
+
+// Up 按顺序执行所有还没执行的迁移，返回这次执行了的
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var res []Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err = m.exec(conn, mg.Up); err != nil {
+				return fmt.Errorf("执行迁移 %d_%s 失败：%w", mg.Version, mg.Name, err)
+			}
+			err = conn.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				mg.Version, mg.Name, time.Now().UnixMilli()).Error
+			if err != nil {
+				return err
+			}
+			res = append(res, mg)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Down 回滚最后执行的一个迁移
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var res Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if err = m.exec(conn, mg.Down); err != nil {
+				return fmt.Errorf("回滚迁移 %d_%s 失败：%w", mg.Version, mg.Name, err)
+			}
+			res = mg
+			return conn.Exec("DELETE FROM schema_migrations WHERE version = ?", mg.Version).Error
+		}
+		return ErrNoApplied
+	})
+	return res, err
+}
+
+// Status 所有迁移的执行情况，按版本号排序
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn := m.db.WithContext(ctx)
+	if err := m.ensureTable(conn); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		res = append(res, Status{Migration: mg, AppliedAt: applied[mg.Version]})
+	}
+	return res, nil
+}
+
+// withLock 在同一个连接上加锁、执行迁移、释放锁
+// MySQL 用 GET_LOCK，锁跟着连接走，进程挂了连接断开锁也就释放了；其他数据库是单机的，不需要加锁
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if conn.Dialector.Name() == "mysql" {
+			var locked sql.NullInt64
+			err := conn.Raw("SELECT GET_LOCK(?, ?)", m.lockName, int(m.lockTimeout.Seconds())).Scan(&locked).Error
+			if err != nil {
+				return err
+			}
+			if locked.Int64 != 1 {
+				return ErrLocked
+			}
+			defer conn.Exec("SELECT RELEASE_LOCK(?)", m.lockName)
+		}
+		if err := m.ensureTable(conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) ensureTable(conn *gorm.DB) error {
+	return conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT       NOT NULL PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    applied_at BIGINT       NOT NULL
+)`).Error
+}
+
+// applied 已经执行过的版本和执行时间
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]time.Time, error) {
+	var rows []struct {
+		Version   int64
+		AppliedAt int64
+	}
+	err := conn.Raw("SELECT version, applied_at FROM schema_migrations").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64]time.Time, len(rows))
+	for _, r := range rows {
+		res[r.Version] = time.UnixMilli(r.AppliedAt)
+	}
+	return res, nil
+}
+
+func (m *Migrator) exec(conn *gorm.DB, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if err := conn.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load 读取并校验迁移文件：版本号不能重复，每个版本都要有 up 和 down
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		version, name, direction, err := parseFileName(path.Base(file))
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: name}
+			byVersion[version] = mg
+		}
+		if mg.Name != name {
+			return nil, fmt.Errorf("版本 %d 有两个不同的名字 %s 和 %s", version, mg.Name, name)
+		}
+		if direction == "up" {
+			mg.Up = string(content)
+		} else {
+			mg.Down = string(content)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("迁移 %d_%s 缺少 up 或者 down 文件", mg.Version, mg.Name)
+		}
+		res = append(res, *mg)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	return res, nil
+}
+
+// parseFileName 0001_create_users.up.sql => 1, create_users, up
+func parseFileName(file string) (int64, string, string, error) {
+	base := strings.TrimSuffix(file, ".sql")
+	direction := path.Ext(base)
+	if direction != ".up" && direction != ".down" {
+		return 0, "", "", fmt.Errorf("迁移文件 %s 必须以 .up.sql 或者 .down.sql 结尾", file)
+	}
+	base = strings.TrimSuffix(base, direction)
+	versionStr, name, found := strings.Cut(base, "_")
+	if !found || name == "" {
+		return 0, "", "", fmt.Errorf("迁移文件 %s 的名字格式不对", file)
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("迁移文件 %s 的版本号不对", file)
+	}
+	return version, name, direction[1:], nil
+}
+
+// splitStatements 按行尾的分号拆成多条语句，去掉只有注释的部分
+func splitStatements(script string) []string {
+	var (
+		res []string
+		buf strings.Builder
+	)
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if hasCode(stmt) {
+			res = append(res, stmt)
+		}
+	}
+	for _, line := range strings.Split(script, "\n") {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			flush()
+		}
+	}
+	flush()
+	return res
+}
+
+func hasCode(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_version.up.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN version BIGINT;")},
+		"0002_add_version.down.sql":  {Data: []byte("ALTER TABLE users DROP COLUMN version;")},
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"0003_missing_down.up.sql":   {Data: []byte("SELECT 1;")},
+	}
+	if _, err := load(fsys); err == nil {
+		t.Fatal("缺少 down 文件应该报错")
+	}
+
+	delete(fsys, "0003_missing_down.up.sql")
+	migrations, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "add_version" {
+		t.Fatalf("迁移没有按版本号排序 %+v", migrations)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- 第一条
+CREATE TABLE a (
+    id BIGINT
+);
+-- 第二条
+INSERT INTO a VALUES (1);
+-- 只有注释
+`
+	want := []string{
+		"-- 第一条\nCREATE TABLE a (\n    id BIGINT\n);",
+		"-- 第二条\nINSERT INTO a VALUES (1);",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("期望 %q，实际 %q", want, got)
+	}
+}
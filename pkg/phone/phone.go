@@ -0,0 +1,111 @@
+package phone
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidNumber = errors.New("手机号码格式不正确")
+	ErrUnknownRegion = errors.New("不支持的地区")
+)
+
+// region 一个地区的手机号码规则
+type region struct {
+	// countryCode 国际区号，不带 +
+	countryCode string
+	// trunkPrefix 国内拨号时的长途前缀，例如英国的 0，解析时需要去掉
+	trunkPrefix string
+	// mobile 去掉区号和长途前缀之后的手机号码格式
+	mobile *regexp.Regexp
+}
+
+// regions 目前支持的地区，key 是 ISO 3166-1 的二位地区码
+var regions = map[string]region{
+	"CN": {countryCode: "86", mobile: regexp.MustCompile(`^1[3-9]\d{9}$`)},
+	"HK": {countryCode: "852", mobile: regexp.MustCompile(`^[4-9]\d{7}$`)},
+	"MO": {countryCode: "853", mobile: regexp.MustCompile(`^6\d{7}$`)},
+	"TW": {countryCode: "886", trunkPrefix: "0", mobile: regexp.MustCompile(`^9\d{8}$`)},
+	"SG": {countryCode: "65", mobile: regexp.MustCompile(`^[89]\d{7}$`)},
+	"JP": {countryCode: "81", trunkPrefix: "0", mobile: regexp.MustCompile(`^[789]0\d{8}$`)},
+	"GB": {countryCode: "44", trunkPrefix: "0", mobile: regexp.MustCompile(`^7\d{9}$`)},
+	"US": {countryCode: "1", mobile: regexp.MustCompile(`^[2-9]\d{2}[2-9]\d{6}$`)},
+}
+
+// Parse 解析并校验手机号码，返回 E.164 格式（例如 +8613800000000）
+// 带 + 或者 00 开头的号码按照国际号码解析，否则按照 defaultRegion 解析
+func Parse(raw string, defaultRegion string) (string, error) {
+	num := strip(raw)
+	if num == "" {
+		return "", ErrInvalidNumber
+	}
+
+	switch {
+	case strings.HasPrefix(num, "+"):
+		return parseInternational(num[1:])
+	case strings.HasPrefix(num, "00"):
+		return parseInternational(num[2:])
+	}
+
+	r, ok := regions[strings.ToUpper(defaultRegion)]
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	return r.format(num)
+}
+
+// IsValid 判断是不是一个合法的手机号码
+func IsValid(raw string, defaultRegion string) bool {
+	_, err := Parse(raw, defaultRegion)
+	return err == nil
+}
+
+func parseInternational(num string) (string, error) {
+	if !isDigits(num) {
+		return "", ErrInvalidNumber
+	}
+	// 国际区号是前缀码，不会出现一个区号是另一个区号前缀的情况，所以找到一个就可以
+	for _, r := range regions {
+		if strings.HasPrefix(num, r.countryCode) {
+			return r.format(num[len(r.countryCode):])
+		}
+	}
+	return "", ErrUnknownRegion
+}
+
+func (r region) format(national string) (string, error) {
+	if !isDigits(national) {
+		return "", ErrInvalidNumber
+	}
+	if r.trunkPrefix != "" {
+		national = strings.TrimPrefix(national, r.trunkPrefix)
+	}
+	if !r.mobile.MatchString(national) {
+		return "", ErrInvalidNumber
+	}
+	return "+" + r.countryCode + national, nil
+}
+
+// strip 去掉用户输入里面常见的分隔符
+func strip(raw string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.', '\t':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package phone
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		raw     string
+		region  string
+		want    string
+		wantErr error
+	}{
+		{name: "国内号码", raw: "13800000000", region: "CN", want: "+8613800000000"},
+		{name: "带区号", raw: "+8613800000000", region: "CN", want: "+8613800000000"},
+		{name: "00 开头", raw: "008613800000000", region: "CN", want: "+8613800000000"},
+		{name: "带分隔符", raw: " 138-0000 0000 ", region: "CN", want: "+8613800000000"},
+		{name: "区号优先于默认地区", raw: "+85261234567", region: "CN", want: "+85261234567"},
+		{name: "去掉长途前缀", raw: "07911123456", region: "GB", want: "+447911123456"},
+		{name: "位数不对", raw: "1380000000", region: "CN", wantErr: ErrInvalidNumber},
+		{name: "非数字", raw: "1380000000a", region: "CN", wantErr: ErrInvalidNumber},
+		{name: "空号码", raw: "", region: "CN", wantErr: ErrInvalidNumber},
+		{name: "未知区号", raw: "+999123456", region: "CN", wantErr: ErrUnknownRegion},
+		{name: "未知默认地区", raw: "13800000000", region: "XX", wantErr: ErrUnknownRegion},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.raw, tc.region)
+			if err != tc.wantErr {
+				t.Fatalf("期望错误 %v，实际 %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Fatalf("期望 %s，实际 %s", tc.want, got)
+			}
+		})
+	}
+}
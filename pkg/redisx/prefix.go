@@ -0,0 +1,104 @@
+// Package redisx go-redis 的一些扩展
+package redisx
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// KeyPrefixHook 给所有命令的 key 加上统一的前缀，多个应用共用一个 Redis 的时候用来隔离
+// 注意：
+// 1、前缀里不能有花括号，不然会影响 Redis Cluster 的 hash tag；
+// 2、pub/sub 的频道不走这个 hook，不会加前缀。
+type KeyPrefixHook struct {
+	prefix string
+}
+
+func NewKeyPrefixHook(prefix string) *KeyPrefixHook {
+	return &KeyPrefixHook{prefix: prefix}
+}
+
+func (h *KeyPrefixHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *KeyPrefixHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.prefixKeys(cmd.Args())
+		return next(ctx, cmd)
+	}
+}
+
+func (h *KeyPrefixHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.prefixKeys(cmd.Args())
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// keylessCommands 没有 key 的命令，不能把第一个参数当成 key
+var keylessCommands = map[string]bool{
+	"ping": true, "echo": true, "publish": true, "spublish": true, "info": true, "select": true,
+	"auth": true, "hello": true, "client": true, "cluster": true, "command": true, "script": true,
+	"config": true, "dbsize": true, "time": true, "flushdb": true, "flushall": true,
+	"readonly": true, "readwrite": true, "multi": true, "exec": true, "discard": true,
+	"unwatch": true, "quit": true, "function": true,
+}
+
+// prefixKeys 大部分命令第一个参数就是 key，少数几个命令要特殊处理
+func (h *KeyPrefixHook) prefixKeys(args []any) {
+	if len(args) < 2 {
+		return
+	}
+	name, _ := args[0].(string)
+	switch name = strings.ToLower(name); {
+	case keylessCommands[name]:
+	case name == "eval" || name == "evalsha" || name == "eval_ro" || name == "evalsha_ro" ||
+		name == "fcall" || name == "fcall_ro":
+		// EVAL script numkeys key [key ...] arg [arg ...]
+		if len(args) < 3 {
+			return
+		}
+		numKeys, err := strconv.Atoi(toString(args[2]))
+		if err != nil {
+			return
+		}
+		for i := 3; i < 3+numKeys && i < len(args); i++ {
+			args[i] = h.prefix + toString(args[i])
+		}
+	case name == "del" || name == "unlink" || name == "exists" || name == "touch" ||
+		name == "mget" || name == "watch":
+		for i := 1; i < len(args); i++ {
+			args[i] = h.prefix + toString(args[i])
+		}
+	case name == "mset" || name == "msetnx":
+		for i := 1; i < len(args); i += 2 {
+			args[i] = h.prefix + toString(args[i])
+		}
+	default:
+		args[1] = h.prefix + toString(args[1])
+	}
+}
+
+func toString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,49 @@
+package redisx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyPrefixHook_prefixKeys(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []any
+		want []any
+	}{
+		{
+			name: "普通命令",
+			args: []any{"set", "user:info:1", "v", "ex", 900},
+			want: []any{"set", "app:user:info:1", "v", "ex", 900},
+		},
+		{
+			name: "Lua 脚本只给 key 加前缀",
+			args: []any{"eval", "return 1", 2, "phone_code:{login:+86}", "phone_code:{login:+86}:cnt", "123456"},
+			want: []any{"eval", "return 1", 2, "app:phone_code:{login:+86}", "app:phone_code:{login:+86}:cnt", "123456"},
+		},
+		{
+			name: "多个 key",
+			args: []any{"del", "a", "b"},
+			want: []any{"del", "app:a", "app:b"},
+		},
+		{
+			name: "mset 只给 key 加前缀",
+			args: []any{"mset", "a", "1", "b", "2"},
+			want: []any{"mset", "app:a", "1", "app:b", "2"},
+		},
+		{
+			name: "没有 key 的命令",
+			args: []any{"publish", "user:info:invalidate", "1"},
+			want: []any{"publish", "user:info:invalidate", "1"},
+		},
+	}
+	h := NewKeyPrefixHook("app:")
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h.prefixKeys(tc.args)
+			if !reflect.DeepEqual(tc.args, tc.want) {
+				t.Fatalf("期望 %v，实际 %v", tc.want, tc.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package snowflake
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed lua/renew.lua
+var luaRenew string
+
+var (
+	ErrNoWorkerId = errors.New("没有空闲的 worker id")
+	ErrLeaseLost  = errors.New("worker id 的租约已经丢失")
+)
+
+// Lease 在 Redis 里租用的 worker id
+// 每个 worker id 对应一个 key，谁 SETNX 成功就归谁，后台定时续约
+// 租约丢了之后后台会重新租一个 worker id，租到之前 Current 返回 ErrLeaseLost
+type Lease struct {
+	cmd       redis.Cmdable
+	keyPrefix string
+	token     string
+	ttl       time.Duration
+	stop      context.CancelFunc
+
+	// mu 保护 workerId 和 key，重新租到 worker id 的时候会一起换掉
+	mu       sync.Mutex
+	workerId int64
+	key      string
+	lost     atomic.Bool
+	// validUntil 上一次续约成功之后，最晚用到什么时候（UnixNano）
+	// 按发起续约的时间算，比 key 真正过期的时间早 ttl/3，给续约请求的耗时和时钟误差留出余量
+	validUntil atomic.Int64
+}
+
+// AcquireLease 从 0 开始找一个没有被占用的 worker id，ttl 内没有续约成功就认为租约丢了
+func AcquireLease(ctx context.Context, cmd redis.Cmdable, keyPrefix string, ttl time.Duration) (*Lease, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	l := &Lease{
+		cmd:       cmd,
+		keyPrefix: keyPrefix,
+		token:     hex.EncodeToString(buf),
+		ttl:       ttl,
+	}
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	renewCtx, cancel := context.WithCancel(context.Background())
+	l.stop = cancel
+	go l.keepAlive(renewCtx)
+	return l, nil
+}
+
+// acquire 找一个没有被占用的 worker id 租下来
+func (l *Lease) acquire(ctx context.Context) error {
+	for id := int64(0); id <= MaxWorkerId; id++ {
+		key := fmt.Sprintf("%s:%d", l.keyPrefix, id)
+		start := time.Now()
+		ok, err := l.cmd.SetNX(ctx, key, l.token, l.ttl).Result()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		l.mu.Lock()
+		l.workerId, l.key = id, key
+		l.renewed(start)
+		l.lost.Store(false)
+		l.mu.Unlock()
+		return nil
+	}
+	return ErrNoWorkerId
+}
+
+// WorkerId 当前租到的 worker id，租约丢了之后可能会变
+func (l *Lease) WorkerId() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.workerId
+}
+
+// Current 当前可以使用的 worker id，租约丢了、还没有重新租到的时候返回 ErrLeaseLost
+func (l *Lease) Current() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Err(); err != nil {
+		return 0, err
+	}
+	return l.workerId, nil
+}
+
+// Err 租约丢了，或者太久没有续约成功、快要过期了，返回 ErrLeaseLost
+func (l *Lease) Err() error {
+	if l.lost.Load() || time.Now().UnixNano() >= l.validUntil.Load() {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// renewed 记录一次成功的续约，start 是发起请求的时间，key 的过期时间不会早于 start+ttl
+func (l *Lease) renewed(start time.Time) {
+	l.validUntil.Store(start.Add(l.ttl - l.ttl/3).UnixNano())
+}
+
+// Close 停止续约
+// 不主动删除 key，等它自然过期再让别的实例用，给两个实例之间的时钟误差留出余量
+func (l *Lease) Close() {
+	l.stop()
+}
+
+// keepAlive 每 ttl/3 续约一次，Redis 暂时连不上的时候继续重试，直到超过 validUntil
+// 租约丢了之后每 ttl/3 尝试重新租一个 worker id
+func (l *Lease) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if l.lost.Load() {
+			l.reacquire(ctx)
+			continue
+		}
+		l.renew(ctx)
+	}
+}
+
+func (l *Lease) renew(ctx context.Context) {
+	// 只有 keepAlive 这一个 goroutine 会改 key，这里读不用加锁
+	workerId, key := l.workerId, l.key
+	start := time.Now()
+	// 续约请求卡住的时间也要算进去，不能等到 key 已经过期了才发现
+	rctx, cancel := context.WithTimeout(ctx, l.ttl/3)
+	res, err := l.cmd.Eval(rctx, luaRenew, []string{key}, l.token, l.ttl.Milliseconds()).Int()
+	cancel()
+	switch {
+	case err == nil && res == 1:
+		l.renewed(start)
+	case err == nil:
+		// key 已经过期并且被别的实例抢走了
+		log.Printf("worker id %d 的租约被别的实例占用了", workerId)
+		l.lost.Store(true)
+	case l.Err() != nil:
+		log.Printf("worker id %d 续约失败，租约快要过期了 %v", workerId, err)
+		l.lost.Store(true)
+	default:
+		log.Printf("worker id %d 续约失败，稍后重试 %v", workerId, err)
+	}
+}
+
+func (l *Lease) reacquire(ctx context.Context) {
+	rctx, cancel := context.WithTimeout(ctx, l.ttl/3)
+	defer cancel()
+	if err := l.acquire(rctx); err != nil {
+		log.Printf("重新租用 worker id 失败，稍后重试 %v", err)
+		return
+	}
+	log.Printf("重新租到了 worker id %d", l.WorkerId())
+}
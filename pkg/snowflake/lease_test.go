@@ -0,0 +1,120 @@
+package snowflake
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeaseRedis 只实现了租约用到的 SetNX 和 Eval
+// renewErr 不为空的时候模拟 Redis 连不上，taken 里的 key 模拟被别的实例占用了
+type fakeLeaseRedis struct {
+	redis.Cmdable
+	mu       sync.Mutex
+	renewErr error
+	taken    map[string]bool
+}
+
+func (f *fakeLeaseRedis) SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := redis.NewBoolCmd(ctx)
+	if f.renewErr != nil {
+		cmd.SetErr(f.renewErr)
+	} else {
+		cmd.SetVal(!f.taken[key])
+	}
+	return cmd
+}
+
+func (f *fakeLeaseRedis) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := redis.NewCmd(ctx)
+	switch {
+	case f.renewErr != nil:
+		cmd.SetErr(f.renewErr)
+	case f.taken[keys[0]]:
+		cmd.SetVal(int64(0))
+	default:
+		cmd.SetVal(int64(1))
+	}
+	return cmd
+}
+
+func (f *fakeLeaseRedis) failRenew(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewErr = err
+}
+
+func (f *fakeLeaseRedis) take(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.taken == nil {
+		f.taken = map[string]bool{}
+	}
+	f.taken[key] = true
+}
+
+func TestLease_RenewFailed(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	cmd := &fakeLeaseRedis{}
+	l, err := AcquireLease(context.Background(), cmd, "snowflake:worker", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// 正常续约的时候，超过 ttl 也一直可用
+	time.Sleep(ttl + ttl/2)
+	if err = l.Err(); err != nil {
+		t.Fatalf("续约正常的时候期望可用，实际 %v", err)
+	}
+
+	// Redis 连不上了，最后一次成功续约之后的 ttl 内，key 过期之前就要停止使用
+	cmd.failRenew(errors.New("模拟 Redis 连不上"))
+	time.Sleep(ttl)
+	if err = l.Err(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("期望 ErrLeaseLost，实际 %v", err)
+	}
+}
+
+func TestLease_Reacquire(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	cmd := &fakeLeaseRedis{}
+	l, err := AcquireLease(context.Background(), cmd, "snowflake:worker", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	g, err := NewWithLease(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis 连不上，租约过期了，这段时间不能生成 id
+	cmd.failRenew(errors.New("模拟 Redis 连不上"))
+	time.Sleep(ttl)
+	if _, err = g.Next(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("期望 ErrLeaseLost，实际 %v", err)
+	}
+
+	// Redis 恢复了，原来的 worker id 已经被别的实例抢走，要重新租一个别的
+	cmd.take("snowflake:worker:0")
+	cmd.failRenew(nil)
+	time.Sleep(ttl / 2)
+	id, err := g.Next()
+	if err != nil {
+		t.Fatalf("重新租到 worker id 之后期望可以生成 id，实际 %v", err)
+	}
+	if worker := id >> sequenceBits & MaxWorkerId; worker != 1 {
+		t.Fatalf("期望用新的 worker id 1，实际 %d", worker)
+	}
+}
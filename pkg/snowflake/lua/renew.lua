@@ -0,0 +1,10 @@
+-- worker id 的 key
+local key = KEYS[1]
+-- 租用时候的 token，只能续约自己的租约
+local token = ARGV[1]
+-- 续约的时长，毫秒
+local ttl = ARGV[2]
+if redis.call("get", key) == token then
+    return redis.call("pexpire", key, ttl)
+end
+return 0
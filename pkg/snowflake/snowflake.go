@@ -0,0 +1,106 @@
+// Package snowflake 生成趋势递增的 64 位 id
+//
+// 最高位不用，之后是 41 位毫秒时间戳、10 位 worker id、12 位序列号，
+// 每个 worker 每毫秒最多生成 4096 个 id。id 超过了 JS 的安全整数范围，返回给前端的时候要转成字符串。
+package snowflake
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerBits   = 10
+	sequenceBits = 12
+
+	MaxWorkerId = 1<<workerBits - 1
+	maxSequence = 1<<sequenceBits - 1
+)
+
+// epoch 2024-01-01 00:00:00 UTC，41 位的时间戳从这里开始可以用 69 年
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+var (
+	ErrInvalidWorkerId = errors.New("worker id 超出范围")
+	ErrClockBackwards  = errors.New("时钟回拨")
+)
+
+type Generator struct {
+	mu       sync.Mutex
+	workerId int64
+	lastMs   int64
+	sequence int64
+	// maxBackward 时钟回拨在这个范围内就等时钟追上来，超过了直接报错，避免长时间卡住
+	maxBackward time.Duration
+	// lease 从 Redis 租来的 worker id，为 nil 表示 worker id 是配置的
+	lease *Lease
+	now   func() int64
+}
+
+// New 使用固定的 worker id，多个实例的 worker id 不能重复
+func New(workerId int64) (*Generator, error) {
+	if workerId < 0 || workerId > MaxWorkerId {
+		return nil, fmt.Errorf("%w：%d", ErrInvalidWorkerId, workerId)
+	}
+	return &Generator{
+		workerId:    workerId,
+		maxBackward: 5 * time.Millisecond,
+		now: func() int64 {
+			return time.Now().UnixMilli()
+		},
+	}, nil
+}
+
+// NewWithLease 使用租来的 worker id，租约丢了之后就不再生成 id，避免和抢到同一个 worker id 的实例重复
+// 后台重新租到 worker id 之后，用新的 worker id 继续生成
+func NewWithLease(lease *Lease) (*Generator, error) {
+	g, err := New(lease.WorkerId())
+	if err != nil {
+		return nil, err
+	}
+	g.lease = lease
+	return g, nil
+}
+
+func (g *Generator) Next() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lease != nil {
+		workerId, err := g.lease.Current()
+		if err != nil {
+			return 0, err
+		}
+		g.workerId = workerId
+	}
+	now := g.now()
+	if now < g.lastMs {
+		drift := time.Duration(g.lastMs-now) * time.Millisecond
+		if drift > g.maxBackward {
+			return 0, fmt.Errorf("%w %v", ErrClockBackwards, drift)
+		}
+		now = g.waitUntil(g.lastMs)
+	}
+	if now == g.lastMs {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// 这一毫秒的序列号用完了
+			now = g.waitUntil(g.lastMs + 1)
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = now
+	return (now-epoch)<<(workerBits+sequenceBits) | g.workerId<<sequenceBits | g.sequence, nil
+}
+
+// waitUntil 等到时钟走到 ms
+func (g *Generator) waitUntil(ms int64) int64 {
+	now := g.now()
+	for now < ms {
+		time.Sleep(time.Duration(ms-now) * time.Millisecond)
+		now = g.now()
+	}
+	return now
+}
@@ -0,0 +1,78 @@
+package snowflake
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGenerator_Next(t *testing.T) {
+	g, err := New(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last int64
+	seen := make(map[int64]bool)
+	// 超过一毫秒的序列号上限，要等到下一毫秒
+	for i := 0; i < 3*maxSequence; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("id 应该递增，%d 在 %d 之后", id, last)
+		}
+		if seen[id] {
+			t.Fatalf("id %d 重复了", id)
+		}
+		if worker := id >> sequenceBits & MaxWorkerId; worker != 3 {
+			t.Fatalf("期望 worker id 3，实际 %d", worker)
+		}
+		seen[id] = true
+		last = id
+	}
+}
+
+func TestGenerator_ClockBackwards(t *testing.T) {
+	testCases := []struct {
+		name    string
+		back    int64
+		wantErr error
+	}{
+		{name: "小幅回拨等时钟追上来", back: 2},
+		{name: "回拨太多直接报错", back: 1000, wantErr: ErrClockBackwards},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := New(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, err := g.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 模拟时钟往回拨，之后每次读时钟走一毫秒
+			now := g.lastMs - tc.back
+			g.now = func() int64 {
+				now++
+				return now
+			}
+			id, err := g.Next()
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("期望 %v，实际 %v", tc.wantErr, err)
+			}
+			if err == nil && id <= first {
+				t.Fatalf("时钟回拨之后生成的 id %d 不应该小于 %d", id, first)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidWorkerId(t *testing.T) {
+	for _, id := range []int64{-1, MaxWorkerId + 1} {
+		if _, err := New(id); !errors.Is(err, ErrInvalidWorkerId) {
+			t.Fatalf("worker id %d 期望 ErrInvalidWorkerId，实际 %v", id, err)
+		}
+	}
+}
@@ -3,38 +3,46 @@
 package main
 
 import (
-	"github.com/gin-gonic/gin"
 	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
+	"mini-ebook/internal/job"
 	"mini-ebook/internal/repository"
 	"mini-ebook/internal/repository/cache"
-	"mini-ebook/internal/repository/dao"
 	"mini-ebook/internal/service"
 	"mini-ebook/internal/web"
 	"mini-ebook/ioc"
 )
 
-func InitWebServer() *gin.Engine {
+func InitApp() (*App, func()) {
 	wire.Build(
 		// 初始化 第三方依赖
 		ioc.InitRedis, ioc.InitDB,
+		wire.Bind(new(redis.Cmdable), new(redis.UniversalClient)),
 
 		// 初始化 DAO 依赖
-		dao.NewUserDao,
+		ioc.InitIdGenerator, ioc.InitUserDAO,
 
 		// 初始化 cache 依赖
-		cache.NewUserCache, cache.NewCodeCache,
+		ioc.InitUserCache, cache.NewCodeCache, cache.NewMagicLinkCache, cache.NewLoginAttemptCache,
 
 		// 初始化 repository 依赖
-		repository.NewCachedUserRepository, repository.NewCodeRepository,
+		ioc.InitUserRepository, repository.NewCodeRepository, repository.NewMagicLinkRepository,
+		repository.NewLoginAttemptRepository,
 
 		// 初始化 service 依赖
-		ioc.InitSMSService, service.NewUserService, service.NewCodeService,
+		ioc.InitSMSService, ioc.InitSMSTemplates, ioc.InitMailService, ioc.InitPasswordHasher, ioc.InitObjectStorage,
+		service.NewUserService, service.NewCodeService, ioc.InitMagicLinkService, service.NewAvatarService,
 
 		// 初始化 handler 依赖
-		web.NewUserHandler,
+		web.NewUserHandler, web.NewAdminUserHandler,
 
 		// 初始化 middleware web
 		ioc.InitGinMiddlewares, ioc.InitWebServer,
+
+		// 初始化后台任务
+		job.NewPurgeDeletedUserJob,
+
+		wire.Struct(new(App), "*"),
 	)
-	return gin.Default()
+	return new(App), nil
 }
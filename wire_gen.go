@@ -7,10 +7,9 @@
 package main
 
 import (
-	"github.com/gin-gonic/gin"
+	"mini-ebook/internal/job"
 	"mini-ebook/internal/repository"
 	"mini-ebook/internal/repository/cache"
-	"mini-ebook/internal/repository/dao"
 	"mini-ebook/internal/service"
 	"mini-ebook/internal/web"
 	"mini-ebook/ioc"
@@ -18,19 +17,38 @@ import (
 
 // Injectors from wire.go:
 
-func InitWebServer() *gin.Engine {
-	cmdable := ioc.InitRedis()
-	v := ioc.InitGinMiddlewares(cmdable)
+func InitApp() (*App, func()) {
+	universalClient := ioc.InitRedis()
 	db := ioc.InitDB()
-	userDAO := dao.NewUserDao(db)
-	userCache := cache.NewUserCache(cmdable)
-	userRepository := repository.NewCachedUserRepository(userDAO, userCache)
-	userService := service.NewUserService(userRepository)
-	codeCache := cache.NewCodeCache(cmdable)
-	codeRepository := repository.NewCodeRepository(codeCache)
+	idGenerator := ioc.InitIdGenerator(universalClient)
+	userDAO := ioc.InitUserDAO(db, idGenerator)
+	userCache, cleanup := ioc.InitUserCache(universalClient)
+	userRepository := ioc.InitUserRepository(userDAO, userCache, universalClient)
+	loginAttemptCache := cache.NewLoginAttemptCache(universalClient)
+	loginAttemptRepository := repository.NewLoginAttemptRepository(loginAttemptCache)
+	hasher := ioc.InitPasswordHasher()
 	smsService := ioc.InitSMSService()
-	codeService := service.NewCodeService(codeRepository, smsService)
-	userHandler := web.NewUserHandler(userService, codeService)
-	engine := ioc.InitWebServer(v, userHandler)
-	return engine
+	mailService := ioc.InitMailService()
+	templates := ioc.InitSMSTemplates()
+	storageService := ioc.InitObjectStorage()
+	avatarService := service.NewAvatarService(userRepository, storageService)
+	userService := service.NewUserService(userRepository, loginAttemptRepository, hasher, smsService, mailService, templates, avatarService)
+	v := ioc.InitGinMiddlewares(universalClient, userService)
+	codeCache := cache.NewCodeCache(universalClient)
+	codeRepository := repository.NewCodeRepository(codeCache)
+	codeService := service.NewCodeService(codeRepository, smsService, mailService, templates)
+	magicLinkCache := cache.NewMagicLinkCache(universalClient)
+	magicLinkRepository := repository.NewMagicLinkRepository(magicLinkCache)
+	magicLinkService := ioc.InitMagicLinkService(magicLinkRepository, mailService)
+	userHandler := web.NewUserHandler(userService, codeService, magicLinkService, avatarService)
+	adminUserHandler := web.NewAdminUserHandler(userService)
+	engine := ioc.InitWebServer(v, userHandler, adminUserHandler)
+	purgeDeletedUserJob := job.NewPurgeDeletedUserJob(userService)
+	app := &App{
+		server:       engine,
+		purgeUserJob: purgeDeletedUserJob,
+	}
+	return app, func() {
+		cleanup()
+	}
 }